@@ -2,8 +2,12 @@ package auth
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"strings"
 
+	"github.com/t34-dev/go-utils/pkg/db"
 	"github.com/t34-dev/go-utils/pkg/sys"
 	"github.com/t34-dev/go-utils/pkg/sys/codes"
 	"google.golang.org/grpc"
@@ -15,8 +19,9 @@ type contextKey string
 const userClaimsKey contextKey = "user_claims"
 
 type UserClaims struct {
-	UserID string
-	Role   string
+	UserID   string
+	Role     string
+	TenantID string
 }
 
 func JWTAuthInterceptor() grpc.UnaryServerInterceptor {
@@ -39,17 +44,44 @@ func JWTAuthInterceptor() grpc.UnaryServerInterceptor {
 		}
 
 		newCtx := context.WithValue(ctx, userClaimsKey, claims)
+		if claims.TenantID != "" {
+			// clients with tenant isolation run the request queries for this tenant
+			newCtx = db.WithTenant(newCtx, claims.TenantID)
+		}
 
 		return handler(newCtx, req)
 	}
 }
 
+// tokenClaims is the JWT payload
+type tokenClaims struct {
+	Subject  string `json:"sub"`
+	Role     string `json:"role"`
+	TenantID string `json:"tenant_id"`
+}
+
 func extractClaimsFromToken(tokenString string) (*UserClaims, error) {
-	// Here should be the logic for extracting data from the token
-	// For example, we'll just create dummy data
+	// Here should be the logic for verifying the token signature,
+	// for now the claims are only read from the payload
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims tokenClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+
 	return &UserClaims{
-		UserID: "123",
-		Role:   "user",
+		UserID:   claims.Subject,
+		Role:     claims.Role,
+		TenantID: claims.TenantID,
 	}, nil
 }
 
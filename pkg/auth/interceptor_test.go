@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func token(payload string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestJWTAuthInterceptor(t *testing.T) {
+	interceptor := JWTAuthInterceptor()
+	call := func(authorization string) (context.Context, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", authorization))
+
+		var handled context.Context
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			handled = ctx
+			return nil, nil
+		})
+		return handled, err
+	}
+
+	t.Run("claims and tenant", func(t *testing.T) {
+		ctx, err := call(token(`{"sub":"42","role":"admin","tenant_id":"acme"}`))
+		require.NoError(t, err)
+
+		claims, ok := GetUserClaimsFromContext(ctx)
+		require.True(t, ok)
+		require.Equal(t, &UserClaims{UserID: "42", Role: "admin", TenantID: "acme"}, claims)
+
+		tenantID, ok := db.TenantFromContext(ctx)
+		require.True(t, ok)
+		require.Equal(t, "acme", tenantID)
+	})
+
+	t.Run("without tenant", func(t *testing.T) {
+		ctx, err := call(token(`{"sub":"42","role":"user"}`))
+		require.NoError(t, err)
+
+		_, ok := db.TenantFromContext(ctx)
+		require.False(t, ok)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		for _, authorization := range []string{"Bearer abc", token("not json")} {
+			_, err := call(authorization)
+			require.Equal(t, codes.Unauthenticated, sys.GetError(err).Code(), authorization)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package db
+
+import "github.com/jackc/pgconn"
+
+// BatchItem is a single query queued in a Batch
+type BatchItem struct {
+	Query Query
+	Args  []interface{}
+}
+
+// Batch queues named queries that are sent to the database in a single round trip
+type Batch struct {
+	items []BatchItem
+}
+
+// BatchResult is the result of a single queued query
+type BatchResult struct {
+	Query      Query
+	CommandTag pgconn.CommandTag
+}
+
+// Queue adds a query to the batch
+func (b *Batch) Queue(q Query, args ...interface{}) {
+	b.items = append(b.items, BatchItem{Query: q, Args: args})
+}
+
+// Items returns the queued queries in order
+func (b *Batch) Items() []BatchItem {
+	return b.items
+}
+
+// Len returns the number of queued queries
+func (b *Batch) Len() int {
+	return len(b.items)
+}
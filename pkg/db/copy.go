@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// CopyFromRows returns a COPY FROM source over a slice of rows
+func CopyFromRows(rows [][]interface{}) pgx.CopyFromSource {
+	return pgx.CopyFromRows(rows)
+}
+
+// CopyFromSlice returns a COPY FROM source over a slice of any type, next converts the i-th element to a row
+func CopyFromSlice(length int, next func(i int) ([]interface{}, error)) pgx.CopyFromSource {
+	return pgx.CopyFromSlice(length, next)
+}
+
+// CopyFromChannel returns a COPY FROM source that reads rows from ch until it is closed or ctx is done
+func CopyFromChannel(ctx context.Context, ch <-chan []interface{}) pgx.CopyFromSource {
+	return &copyFromChannel{ctx: ctx, ch: ch}
+}
+
+type copyFromChannel struct {
+	ctx context.Context
+	ch  <-chan []interface{}
+	row []interface{}
+	err error
+}
+
+func (c *copyFromChannel) Next() bool {
+	select {
+	case <-c.ctx.Done():
+		c.err = c.ctx.Err()
+		return false
+	case row, ok := <-c.ch:
+		if !ok {
+			return false
+		}
+		c.row = row
+		return true
+	}
+}
+
+func (c *copyFromChannel) Values() ([]interface{}, error) {
+	return c.row, nil
+}
+
+func (c *copyFromChannel) Err() error {
+	return c.err
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyFromChannel(t *testing.T) {
+	t.Run("reads rows until the channel is closed", func(t *testing.T) {
+		ch := make(chan []interface{}, 2)
+		ch <- []interface{}{1, "first"}
+		ch <- []interface{}{2, "second"}
+		close(ch)
+
+		src := CopyFromChannel(context.Background(), ch)
+
+		var rows [][]interface{}
+		for src.Next() {
+			row, err := src.Values()
+			require.NoError(t, err)
+			rows = append(rows, row)
+		}
+		require.NoError(t, src.Err())
+		require.Equal(t, [][]interface{}{{1, "first"}, {2, "second"}}, rows)
+	})
+
+	t.Run("stops when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		src := CopyFromChannel(ctx, make(chan []interface{}))
+		require.False(t, src.Next())
+		require.ErrorIs(t, src.Err(), context.Canceled)
+	})
+}
@@ -0,0 +1,244 @@
+// Package crud builds INSERT, UPDATE and upsert statements from the db tags of a struct.
+// Columns are mapped like scany does, tag options after the comma control writes:
+//
+//	ID        int64     `db:"id,readonly"`         // generated by the database: never written, returned
+//	CreatedAt time.Time `db:"created_at,readonly"` // same for columns with defaults
+//	Author    string    `db:"author,omit"`         // not a table column, e.g. selected with a join: skipped
+//	Version   int64     `db:"version,version"`     // optimistic locking column, see UpdateVersioned
+//
+// All statements return the row with RETURNING, so generated values are scanned back into the struct.
+package crud
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/internal/fields"
+)
+
+// Tag options
+const (
+	OptionReadOnly = "readonly"
+	OptionOmit     = "omit"
+)
+
+var (
+	// ErrNoChanges is returned for updates without any column to set
+	ErrNoChanges = errors.New("crud: nothing to update")
+	// ErrNoWhere is returned for updates without a condition, they would change every row of the table
+	ErrNoWhere = errors.New("crud: update without where")
+)
+
+type column struct {
+	name  string
+	value interface{}
+}
+
+// Insert builds an INSERT of all writable columns of v returning all columns
+func Insert(table string, v interface{}) (sq.InsertBuilder, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return sq.InsertBuilder{}, err
+	}
+
+	columns := writable(rv, nil)
+	return insert(table, columns).Suffix("RETURNING " + returning(rv.Type())), nil
+}
+
+// Update builds an UPDATE of the non-zero writable columns of v, use UpdateChanged to set zero values
+func Update(table string, v interface{}, where sq.Sqlizer) (sq.UpdateBuilder, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return sq.UpdateBuilder{}, err
+	}
+
+	return update(table, rv, writable(rv, func(f fields.Field) bool {
+		return !f.Value(rv).IsZero()
+	}), where)
+}
+
+// UpdateChanged builds an UPDATE of the writable columns that differ between old and v
+func UpdateChanged(table string, old, v interface{}, where sq.Sqlizer) (sq.UpdateBuilder, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return sq.UpdateBuilder{}, err
+	}
+	ro, err := structValue(old)
+	if err != nil {
+		return sq.UpdateBuilder{}, err
+	}
+	if ro.Type() != rv.Type() {
+		return sq.UpdateBuilder{}, errors.Errorf("crud: can't compare %s with %s", ro.Type(), rv.Type())
+	}
+
+	return update(table, rv, writable(rv, func(f fields.Field) bool {
+		return !reflect.DeepEqual(f.Value(ro).Interface(), f.Value(rv).Interface())
+	}), where)
+}
+
+// Upsert builds an INSERT of all writable columns of v that updates them on a conflict on the conflict columns
+func Upsert(table string, v interface{}, conflict ...string) (sq.InsertBuilder, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return sq.InsertBuilder{}, err
+	}
+	if len(conflict) == 0 {
+		return sq.InsertBuilder{}, errors.New("crud: upsert needs conflict columns")
+	}
+
+	isConflict := make(map[string]bool, len(conflict))
+	for _, c := range conflict {
+		isConflict[c] = true
+	}
+
+	columns := writable(rv, nil)
+	set := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if !isConflict[c.name] {
+			set = append(set, c.name+" = EXCLUDED."+c.name)
+		}
+	}
+
+	action := "DO NOTHING"
+	if len(set) > 0 {
+		action = "DO UPDATE SET " + strings.Join(set, ", ")
+	}
+
+	return insert(table, columns).Suffix(
+		"ON CONFLICT (" + strings.Join(conflict, ", ") + ") " + action + " RETURNING " + returning(rv.Type()),
+	), nil
+}
+
+// InsertOne inserts v and scans the returned row back into it
+func InsertOne[T any](ctx context.Context, e db.NamedExecer, name, table string, v *T) error {
+	b, err := Insert(table, v)
+	if err != nil {
+		return err
+	}
+
+	return db.ScanOneBuilder(ctx, e, v, name, b)
+}
+
+// UpdateOne updates the non-zero columns of v in the row matching where and scans it back into v.
+// It returns db.ErrNotFound when no row matches.
+func UpdateOne[T any](ctx context.Context, e db.NamedExecer, name, table string, v *T, where sq.Sqlizer) error {
+	b, err := Update(table, v, where)
+	if err != nil {
+		return err
+	}
+
+	return scanReturning(ctx, e, v, name, b)
+}
+
+// UpsertOne upserts v and scans the resulting row back into it.
+// When all columns are conflict columns nothing is returned on a conflict and db.ErrNotFound is returned.
+func UpsertOne[T any](ctx context.Context, e db.NamedExecer, name, table string, v *T, conflict ...string) error {
+	b, err := Upsert(table, v, conflict...)
+	if err != nil {
+		return err
+	}
+
+	return scanReturning(ctx, e, v, name, b)
+}
+
+func scanReturning(ctx context.Context, e db.NamedExecer, dest interface{}, name string, b sq.Sqlizer) error {
+	err := db.ScanOneBuilder(ctx, e, dest, name, b)
+	if db.IsNotFound(err) {
+		return errors.Wrapf(db.ErrNotFound, "query %s", name)
+	}
+
+	return err
+}
+
+func insert(table string, columns []column) sq.InsertBuilder {
+	names := make([]string, len(columns))
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		names[i], values[i] = c.name, c.value
+	}
+
+	return sq.Insert(table).Columns(names...).Values(values...)
+}
+
+func update(table string, rv reflect.Value, columns []column, where sq.Sqlizer) (sq.UpdateBuilder, error) {
+	if err := checkWhere(where); err != nil {
+		return sq.UpdateBuilder{}, err
+	}
+	if len(columns) == 0 {
+		return sq.UpdateBuilder{}, ErrNoChanges
+	}
+
+	b := sq.Update(table)
+	for _, c := range columns {
+		b = b.Set(c.name, c.value)
+	}
+
+	return b.Where(where).Suffix("RETURNING " + returning(rv.Type())), nil
+}
+
+// checkWhere returns ErrNoWhere when where is nil or renders to an empty condition,
+// squirrel renders empty sq.And, sq.Or and sq.Eq as (1=1)
+func checkWhere(where sq.Sqlizer) error {
+	if where == nil {
+		return ErrNoWhere
+	}
+
+	sql, _, err := where.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "crud: invalid where")
+	}
+	if sql = strings.TrimSpace(sql); sql == "" || sql == "(1=1)" {
+		return ErrNoWhere
+	}
+
+	return nil
+}
+
+// writable returns the columns that can be written and pass the filter
+func writable(rv reflect.Value, filter func(f fields.Field) bool) []column {
+	var columns []column
+	for _, f := range fields.Of(rv.Type()) {
+		if f.HasOption(OptionReadOnly) || f.HasOption(OptionOmit) {
+			continue
+		}
+		if filter != nil && !filter(f) {
+			continue
+		}
+
+		columns = append(columns, column{name: f.Column, value: f.Value(rv).Interface()})
+	}
+
+	return columns
+}
+
+// returning lists all table columns of t
+func returning(t reflect.Type) string {
+	var names []string
+	for _, f := range fields.Of(t) {
+		if !f.HasOption(OptionOmit) {
+			names = append(names, f.Column)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, errors.New("crud: nil value")
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, errors.Errorf("crud: %s is not a struct", rv.Type())
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,199 @@
+package crud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+type user struct {
+	ID        int64     `db:"id,readonly"`
+	Email     string    `db:"email"`
+	Name      string    `db:"name"`
+	Age       int       `db:"age"`
+	CreatedAt time.Time `db:"created_at,readonly"`
+	Team      string    `db:"team,omit"`
+}
+
+func toSQL(t *testing.T, b sq.Sqlizer) (string, []interface{}) {
+	q, args, err := db.ToQuery("test", b)
+	require.NoError(t, err)
+
+	return q.QueryRaw, args
+}
+
+func TestBuilders(t *testing.T) {
+	u := user{ID: 1, Email: "john@example.com", Name: "John", Team: "core"}
+
+	t.Run("insert", func(t *testing.T) {
+		b, err := Insert("users", &u)
+		require.NoError(t, err)
+
+		sql, args := toSQL(t, b)
+		require.Equal(t, "INSERT INTO users (email,name,age) VALUES ($1,$2,$3) RETURNING id, email, name, age, created_at", sql)
+		require.Equal(t, []interface{}{"john@example.com", "John", 0}, args)
+	})
+
+	t.Run("update non-zero", func(t *testing.T) {
+		b, err := Update("users", u, sq.Eq{"id": u.ID})
+		require.NoError(t, err)
+
+		sql, args := toSQL(t, b)
+		require.Equal(t, "UPDATE users SET email = $1, name = $2 WHERE id = $3 RETURNING id, email, name, age, created_at", sql)
+		require.Equal(t, []interface{}{"john@example.com", "John", int64(1)}, args)
+	})
+
+	t.Run("update changed", func(t *testing.T) {
+		changed := u
+		changed.Name = ""
+		changed.Age = 30
+		changed.ID = 2
+
+		b, err := UpdateChanged("users", u, changed, sq.Eq{"id": u.ID})
+		require.NoError(t, err)
+
+		sql, args := toSQL(t, b)
+		require.Equal(t, "UPDATE users SET name = $1, age = $2 WHERE id = $3 RETURNING id, email, name, age, created_at", sql)
+		require.Equal(t, []interface{}{"", 30, int64(1)}, args)
+
+		_, err = UpdateChanged("users", u, u, sq.Eq{"id": u.ID})
+		require.ErrorIs(t, err, ErrNoChanges)
+	})
+
+	t.Run("update without where", func(t *testing.T) {
+		for _, where := range []sq.Sqlizer{nil, sq.And{}, sq.Eq{}, sq.Expr("")} {
+			_, err := Update("users", u, where)
+			require.ErrorIs(t, err, ErrNoWhere)
+
+			_, err = UpdateChanged("users", user{}, u, where)
+			require.ErrorIs(t, err, ErrNoWhere)
+		}
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		b, err := Upsert("users", u, "email")
+		require.NoError(t, err)
+
+		sql, _ := toSQL(t, b)
+		require.Equal(t, "INSERT INTO users (email,name,age) VALUES ($1,$2,$3) "+
+			"ON CONFLICT (email) DO UPDATE SET name = EXCLUDED.name, age = EXCLUDED.age "+
+			"RETURNING id, email, name, age, created_at", sql)
+	})
+
+	t.Run("not a struct", func(t *testing.T) {
+		_, err := Insert("users", 42)
+		require.Error(t, err)
+
+		_, err = Insert("users", (*user)(nil))
+		require.Error(t, err)
+	})
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	returned := func() *dbtest.Rows {
+		return dbtest.NewRows("id", "email", "name", "age", "created_at").
+			AddRow(int64(10), "john@example.com", "John", 0, createdAt)
+	}
+
+	t.Run("insert scans generated columns", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Create").WithArgs("john@example.com", "John", 0).WillReturnRows(returned())
+
+		u := user{Email: "john@example.com", Name: "John", Team: "core"}
+		require.NoError(t, InsertOne(ctx, m, "user.Create", "users", &u))
+		require.Equal(t, int64(10), u.ID)
+		require.Equal(t, createdAt, u.CreatedAt)
+		require.Equal(t, "core", u.Team)
+	})
+
+	t.Run("update of a missing row", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Update").WillReturnRows(dbtest.NewRows("id", "email", "name", "age", "created_at"))
+
+		u := user{Name: "John"}
+		err := UpdateOne(ctx, m, "user.Update", "users", &u, sq.Eq{"id": 10})
+		require.ErrorIs(t, err, db.ErrNotFound)
+		require.Contains(t, err.Error(), "user.Update")
+	})
+}
+
+type document struct {
+	ID      int64  `db:"id,readonly"`
+	Title   string `db:"title"`
+	Version int64  `db:"version,version"`
+}
+
+func TestVersioned(t *testing.T) {
+	ctx := context.Background()
+	doc := document{ID: 1, Title: "Draft", Version: 3}
+
+	t.Run("builder", func(t *testing.T) {
+		b, err := UpdateVersioned("documents", doc, sq.Eq{"id": doc.ID})
+		require.NoError(t, err)
+
+		sql, args := toSQL(t, b)
+		require.Equal(t, "UPDATE documents SET title = $1, version = version + 1 WHERE id = $2 AND version = $3 RETURNING id, title, version", sql)
+		require.Equal(t, []interface{}{"Draft", int64(1), int64(3)}, args)
+
+		_, err = UpdateVersioned("users", user{}, sq.Eq{"id": 1})
+		require.Error(t, err)
+
+		_, err = UpdateVersioned("documents", doc, nil)
+		require.ErrorIs(t, err, ErrNoWhere)
+	})
+
+	t.Run("new version is scanned", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("document.Update").
+			WillReturnRows(dbtest.NewRows("id", "title", "version").AddRow(int64(1), "Draft", int64(4)))
+
+		d := doc
+		require.NoError(t, UpdateOneVersioned(ctx, m, "document.Update", "documents", &d, sq.Eq{"id": d.ID}))
+		require.Equal(t, int64(4), d.Version)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("document.Update").WillReturnRows(dbtest.NewRows("id", "title", "version"))
+
+		d := doc
+		err := UpdateOneVersioned(ctx, m, "document.Update", "documents", &d, sq.Eq{"id": d.ID})
+		require.ErrorIs(t, err, ErrVersionConflict)
+		require.Equal(t, codes.Aborted, sys.GetError(err).Code())
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	base := sq.Select("d.id", "d.title").From("documents d")
+
+	sql, _ := toSQL(t, NotDeleted(ctx, base, "d"))
+	require.Equal(t, "SELECT d.id, d.title FROM documents d WHERE d.deleted_at IS NULL", sql)
+
+	sql, _ = toSQL(t, NotDeleted(WithDeleted(ctx), base, "d"))
+	require.Equal(t, "SELECT d.id, d.title FROM documents d", sql)
+
+	m := dbtest.New()
+	m.ExpectExecSQL(`^UPDATE documents SET deleted_at = now\(\) WHERE id = \$1 AND deleted_at IS NULL$`).
+		WithArgs(int64(1)).
+		WillReturnResult("UPDATE 1")
+
+	n, err := SoftDelete(ctx, m, "document.Delete", "documents", sq.Eq{"id": int64(1)})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	_, err = SoftDelete(ctx, m, "document.Delete", "documents", nil)
+	require.ErrorIs(t, err, ErrNoWhere)
+	_, err = Restore(ctx, m, "document.Restore", "documents", sq.And{})
+	require.ErrorIs(t, err, ErrNoWhere)
+	require.NoError(t, m.ExpectationsWereMet())
+}
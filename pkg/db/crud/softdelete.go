@@ -0,0 +1,77 @@
+package crud
+
+import (
+	"context"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// DeletedAtColumn is the timestamp column of soft-deleted rows
+const DeletedAtColumn = "deleted_at"
+
+type includeDeletedKey struct{}
+
+// WithDeleted returns a context in which NotDeleted doesn't filter out soft-deleted rows
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+// IncludesDeleted reports whether ctx opted in to soft-deleted rows
+func IncludesDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(includeDeletedKey{}).(bool)
+	return include
+}
+
+// NotDeleted adds the deleted_at IS NULL scope to a select unless ctx opted in with WithDeleted.
+// alias qualifies the column when the select joins tables, pass an empty alias otherwise.
+func NotDeleted(ctx context.Context, b sq.SelectBuilder, alias string) sq.SelectBuilder {
+	if IncludesDeleted(ctx) {
+		return b
+	}
+
+	return b.Where(sq.Eq{deletedAt(alias): nil})
+}
+
+// SoftDelete sets deleted_at on the rows matching where that are not deleted yet and returns their number
+func SoftDelete(ctx context.Context, e db.QueryExecer, name, table string, where sq.Sqlizer) (int64, error) {
+	if err := checkWhere(where); err != nil {
+		return 0, err
+	}
+
+	tag, err := db.ExecBuilder(ctx, e, name, sq.Update(table).
+		Set(DeletedAtColumn, sq.Expr("now()")).
+		Where(where).
+		Where(sq.Eq{DeletedAtColumn: nil}))
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't soft delete from %s", table)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Restore clears deleted_at on the rows matching where and returns their number
+func Restore(ctx context.Context, e db.QueryExecer, name, table string, where sq.Sqlizer) (int64, error) {
+	if err := checkWhere(where); err != nil {
+		return 0, err
+	}
+
+	tag, err := db.ExecBuilder(ctx, e, name, sq.Update(table).
+		Set(DeletedAtColumn, nil).
+		Where(where).
+		Where(sq.NotEq{DeletedAtColumn: nil}))
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't restore in %s", table)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func deletedAt(alias string) string {
+	if alias == "" {
+		return DeletedAtColumn
+	}
+
+	return alias + "." + DeletedAtColumn
+}
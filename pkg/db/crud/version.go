@@ -0,0 +1,71 @@
+package crud
+
+import (
+	"context"
+	"reflect"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/internal/fields"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// OptionVersion marks the integer column used for optimistic locking, for example `db:"version,version"`
+const OptionVersion = "version"
+
+// ErrVersionConflict is returned by UpdateVersioned when the row was changed or deleted since it was read
+var ErrVersionConflict error = sys.NewError("row was modified concurrently, please retry", codes.Aborted)
+
+// UpdateVersioned builds an UPDATE of all writable columns of v that only applies when the version column
+// still has the value from v, the version is incremented by the database
+func UpdateVersioned(table string, v interface{}, where sq.Sqlizer) (sq.UpdateBuilder, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return sq.UpdateBuilder{}, err
+	}
+
+	version, ok := versionField(rv.Type())
+	if !ok {
+		return sq.UpdateBuilder{}, errors.Errorf("crud: %s has no %s column", rv.Type(), OptionVersion)
+	}
+
+	columns := writable(rv, func(f fields.Field) bool {
+		return f.Column != version.Column
+	})
+	columns = append(columns, column{name: version.Column, value: sq.Expr(version.Column + " + 1")})
+
+	b, err := update(table, rv, columns, where)
+	if err != nil {
+		return b, err
+	}
+
+	return b.Where(sq.Eq{version.Column: version.Value(rv).Interface()}), nil
+}
+
+// UpdateOneVersioned runs UpdateVersioned and scans the updated row, with the new version, back into v.
+// It returns ErrVersionConflict when no row was updated.
+func UpdateOneVersioned[T any](ctx context.Context, e db.NamedExecer, name, table string, v *T, where sq.Sqlizer) error {
+	b, err := UpdateVersioned(table, v, where)
+	if err != nil {
+		return err
+	}
+
+	err = db.ScanOneBuilder(ctx, e, v, name, b)
+	if db.IsNotFound(err) {
+		return errors.Wrapf(ErrVersionConflict, "query %s", name)
+	}
+
+	return err
+}
+
+func versionField(t reflect.Type) (fields.Field, bool) {
+	for _, f := range fields.Of(t) {
+		if f.HasOption(OptionVersion) {
+			return f, true
+		}
+	}
+
+	return fields.Field{}, false
+}
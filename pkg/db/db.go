@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
@@ -21,11 +22,18 @@ type TxManager interface {
 	ReadCommitted(ctx context.Context, f Handler) error
 }
 
+// NoTimeout disables the timeout of a query, including the client default
+const NoTimeout time.Duration = -1
+
 // Query wrapper around a query, storing query name and query itself
 // Query name is used for logging and potentially can be used elsewhere, for example, for tracing
+// ReadOnly queries executed outside of a transaction may be routed to a replica
+// Timeout limits the query execution, zero uses the client default and a negative value (NoTimeout) disables it
 type Query struct {
 	Name     string
 	QueryRaw string
+	ReadOnly bool
+	Timeout  time.Duration
 }
 
 // Transactor interface for working with transactions
@@ -52,6 +60,37 @@ type QueryExecer interface {
 	QueryRowContext(ctx context.Context, q Query, args ...interface{}) pgx.Row
 }
 
+// Cursorer interface for reading large results in chunks with a server-side cursor.
+// The cursor lives in the transaction from ctx, or in its own transaction that ends when the rows are closed.
+type Cursorer interface {
+	CursorContext(ctx context.Context, q Query, fetchSize int, args ...interface{}) (pgx.Rows, error)
+}
+
+// Batcher interface for sending several queries in a single round trip
+type Batcher interface {
+	SendBatchContext(ctx context.Context, b *Batch) ([]BatchResult, error)
+}
+
+// Copier interface for bulk loading rows with COPY FROM
+type Copier interface {
+	CopyFromContext(ctx context.Context, name string, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+}
+
+// Conn is a single connection pinned from the pool
+type Conn interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	// Release returns the connection to the pool
+	Release()
+	// Close closes the connection instead of returning it to the pool, for a session state that must not be reused
+	Close(ctx context.Context) error
+}
+
+// Acquirer interface for pinning a single pooled connection, the caller must release or close it
+type Acquirer interface {
+	Acquire(ctx context.Context) (Conn, error)
+}
+
 // Pinger interface for checking connection to database
 type Pinger interface {
 	Ping(ctx context.Context) error
@@ -60,7 +99,11 @@ type Pinger interface {
 // DB interface for working with database
 type DB interface {
 	SQLExecer
+	Cursorer
+	Batcher
+	Copier
 	Transactor
+	Acquirer
 	Pinger
 	Close()
 }
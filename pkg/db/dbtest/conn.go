@@ -0,0 +1,36 @@
+package dbtest
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// conn implements db.Conn on top of the mock, records release and close
+type conn struct {
+	mock *Mock
+}
+
+func (c *conn) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return c.mock.exec(db.Query{QueryRaw: sql}, args, false)
+}
+
+func (c *conn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	r, err := c.mock.query(db.Query{QueryRaw: sql}, args, false)
+	return &row{rows: r, err: err}
+}
+
+func (c *conn) Release() {
+	_, _ = c.mock.next(kindRelease, db.Query{Name: "release"}, nil, false)
+}
+
+func (c *conn) Close(ctx context.Context) error {
+	e, err := c.mock.next(kindClose, db.Query{Name: "close"}, nil, false)
+	if err != nil {
+		return err
+	}
+
+	return e.err
+}
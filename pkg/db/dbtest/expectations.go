@@ -0,0 +1,142 @@
+package dbtest
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+type kind string
+
+const (
+	kindQuery    kind = "query"
+	kindExec     kind = "exec"
+	kindCopy     kind = "copy"
+	kindBegin    kind = "begin"
+	kindCommit   kind = "commit"
+	kindRollback kind = "rollback"
+	kindAcquire  kind = "acquire"
+	kindRelease  kind = "release"
+	kindClose    kind = "close"
+)
+
+// Argument matches a single query argument
+type Argument interface {
+	Match(v interface{}) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(interface{}) bool { return true }
+
+// AnyArg matches any argument value
+func AnyArg() Argument {
+	return anyArg{}
+}
+
+// Expectation is an expected database call
+type Expectation struct {
+	kind     kind
+	name     string
+	sql      *regexp.Regexp
+	args     []interface{}
+	checkArg bool
+	inTx     *bool
+
+	rows *Rows
+	tag  pgconn.CommandTag
+	err  error
+
+	triggered bool
+}
+
+// WithArgs expects the query to be called with exactly these args, Argument values are used as matchers
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.checkArg = true
+	return e
+}
+
+// InTx expects the call to be made inside (true) or outside (false) of a transaction
+func (e *Expectation) InTx(inTx bool) *Expectation {
+	e.inTx = &inTx
+	return e
+}
+
+// WillReturnRows sets the rows returned by the query
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult sets the command tag returned by exec, for example "UPDATE 1"
+func (e *Expectation) WillReturnResult(tag string) *Expectation {
+	e.tag = pgconn.CommandTag(tag)
+	return e
+}
+
+// WillReturnError makes the call fail with err
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) String() string {
+	var b strings.Builder
+	b.WriteString(string(e.kind))
+	if e.name != "" {
+		fmt.Fprintf(&b, " named %q", e.name)
+	}
+	if e.sql != nil {
+		fmt.Fprintf(&b, " matching %q", e.sql.String())
+	}
+	if e.checkArg {
+		fmt.Fprintf(&b, " with args %v", e.args)
+	}
+
+	return b.String()
+}
+
+// match reports whether the call satisfies the expectation, describing the mismatch otherwise
+func (e *Expectation) match(k kind, q db.Query, args []interface{}, inTx bool) error {
+	if e.kind != k {
+		return fmt.Errorf("expected %s, got %s", e, k)
+	}
+
+	if e.name != "" && e.name != q.Name {
+		return fmt.Errorf("expected %s, got %s named %q", e, k, q.Name)
+	}
+
+	if e.sql != nil && !e.sql.MatchString(q.QueryRaw) {
+		return fmt.Errorf("expected %s, got %s %q", e, k, q.QueryRaw)
+	}
+
+	if e.inTx != nil && *e.inTx != inTx {
+		return fmt.Errorf("expected %s with in tx %t, got %t", e, *e.inTx, inTx)
+	}
+
+	if e.checkArg {
+		if len(e.args) != len(args) {
+			return fmt.Errorf("expected %s, got args %v", e, args)
+		}
+
+		for i, expected := range e.args {
+			if m, ok := expected.(Argument); ok {
+				if !m.Match(args[i]) {
+					return fmt.Errorf("expected %s, argument %d %v does not match", e, i, args[i])
+				}
+				continue
+			}
+
+			if !reflect.DeepEqual(expected, args[i]) {
+				return fmt.Errorf("expected %s, argument %d is %v", e, i, args[i])
+			}
+		}
+	}
+
+	return nil
+}
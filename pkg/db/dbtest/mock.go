@@ -0,0 +1,326 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/pg"
+	"github.com/t34-dev/go-utils/pkg/db/transaction"
+)
+
+// ErrNotSupported is returned by operations the mock can't simulate
+var ErrNotSupported = errors.New("dbtest: not supported")
+
+// Call is a recorded database call
+type Call struct {
+	Kind  string
+	Query db.Query
+	Args  []interface{}
+	InTx  bool
+}
+
+// Mock is an in-memory db.DB that matches calls against expectations.
+// Queries are matched by Query.Name or by an SQL regular expression, in order by default.
+type Mock struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+	unordered    bool
+}
+
+var _ db.DB = (*Mock)(nil)
+
+// New creates an empty mock
+func New() *Mock {
+	return &Mock{}
+}
+
+// TxManager returns a transaction manager that begins transactions on the mock
+func (m *Mock) TxManager(opts ...transaction.Option) db.TxManager {
+	return transaction.NewTransactionManager(m, opts...)
+}
+
+// MatchExpectationsInOrder sets whether calls must happen in the order of expectations, true by default
+func (m *Mock) MatchExpectationsInOrder(ordered bool) {
+	m.mu.Lock()
+	m.unordered = !ordered
+	m.mu.Unlock()
+}
+
+// ExpectQuery expects a query (QueryContext, QueryRowContext, CursorContext, ScanOneContext or ScanAllContext) with Query.Name name
+func (m *Mock) ExpectQuery(name string) *Expectation {
+	return m.expect(&Expectation{kind: kindQuery, name: name})
+}
+
+// ExpectQuerySQL expects a query whose SQL matches the regular expression
+func (m *Mock) ExpectQuerySQL(sqlRegex string) *Expectation {
+	return m.expect(&Expectation{kind: kindQuery, sql: regexp.MustCompile(sqlRegex)})
+}
+
+// ExpectExec expects ExecContext or a batch item with Query.Name name
+func (m *Mock) ExpectExec(name string) *Expectation {
+	return m.expect(&Expectation{kind: kindExec, name: name})
+}
+
+// ExpectExecSQL expects ExecContext or a batch item whose SQL matches the regular expression
+func (m *Mock) ExpectExecSQL(sqlRegex string) *Expectation {
+	return m.expect(&Expectation{kind: kindExec, sql: regexp.MustCompile(sqlRegex)})
+}
+
+// ExpectCopyFrom expects CopyFromContext with the given name
+func (m *Mock) ExpectCopyFrom(name string) *Expectation {
+	return m.expect(&Expectation{kind: kindCopy, name: name})
+}
+
+// ExpectBegin expects a transaction to begin
+func (m *Mock) ExpectBegin() *Expectation {
+	return m.expect(&Expectation{kind: kindBegin})
+}
+
+// ExpectCommit expects a transaction to commit
+func (m *Mock) ExpectCommit() *Expectation {
+	return m.expect(&Expectation{kind: kindCommit})
+}
+
+// ExpectRollback expects a transaction to roll back
+func (m *Mock) ExpectRollback() *Expectation {
+	return m.expect(&Expectation{kind: kindRollback})
+}
+
+// ExpectAcquire expects a connection to be pinned with Acquire
+func (m *Mock) ExpectAcquire() *Expectation {
+	return m.expect(&Expectation{kind: kindAcquire})
+}
+
+// ExpectRelease expects a pinned connection to be returned to the pool
+func (m *Mock) ExpectRelease() *Expectation {
+	return m.expect(&Expectation{kind: kindRelease})
+}
+
+// ExpectClose expects a pinned connection to be closed instead of being returned to the pool
+func (m *Mock) ExpectClose() *Expectation {
+	return m.expect(&Expectation{kind: kindClose})
+}
+
+// ExpectationsWereMet returns an error listing the expectations that were not triggered
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var missing []string
+	for _, e := range m.expectations {
+		if !e.triggered {
+			missing = append(missing, e.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("dbtest: expectations were not met: %s", strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// Calls returns all recorded calls, including transaction begin, commit and rollback and pinned connection acquire, release and close
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Call(nil), m.calls...)
+}
+
+func (m *Mock) expect(e *Expectation) *Expectation {
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+
+	return e
+}
+
+// next records the call and returns the expectation it triggers
+func (m *Mock) next(k kind, q db.Query, args []interface{}, inTx bool) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, Call{Kind: string(k), Query: q, Args: args, InTx: inTx})
+
+	var lastErr error
+	for _, e := range m.expectations {
+		if e.triggered {
+			continue
+		}
+
+		err := e.match(k, q, args, inTx)
+		if err == nil {
+			e.triggered = true
+			return e, nil
+		}
+
+		if !m.unordered {
+			return nil, fmt.Errorf("dbtest: %w", err)
+		}
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("dbtest: unexpected %s %q: %w", k, q.Name, lastErr)
+	}
+
+	return nil, fmt.Errorf("dbtest: unexpected %s %q %q, all expectations were already met", k, q.Name, q.QueryRaw)
+}
+
+func (m *Mock) query(q db.Query, args []interface{}, inTx bool) (*rows, error) {
+	e, err := m.next(kindQuery, q, args, inTx)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return e.rows.pgxRows(), nil
+}
+
+func (m *Mock) exec(q db.Query, args []interface{}, inTx bool) (pgconn.CommandTag, error) {
+	e, err := m.next(kindExec, q, args, inTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.tag, e.err
+}
+
+func (m *Mock) ScanOneContext(ctx context.Context, dest interface{}, q db.Query, args ...interface{}) error {
+	r, err := m.query(q, args, inTx(ctx))
+	if err != nil {
+		return err
+	}
+
+	return pgxscan.ScanOne(dest, r)
+}
+
+func (m *Mock) ScanAllContext(ctx context.Context, dest interface{}, q db.Query, args ...interface{}) error {
+	r, err := m.query(q, args, inTx(ctx))
+	if err != nil {
+		return err
+	}
+
+	return pgxscan.ScanAll(dest, r)
+}
+
+func (m *Mock) ExecContext(ctx context.Context, q db.Query, args ...interface{}) (pgconn.CommandTag, error) {
+	return m.exec(q, args, inTx(ctx))
+}
+
+func (m *Mock) QueryContext(ctx context.Context, q db.Query, args ...interface{}) (pgx.Rows, error) {
+	r, err := m.query(q, args, inTx(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CursorContext is matched like QueryContext, the fetch size is ignored
+func (m *Mock) CursorContext(ctx context.Context, q db.Query, fetchSize int, args ...interface{}) (pgx.Rows, error) {
+	return m.QueryContext(ctx, q, args...)
+}
+
+func (m *Mock) QueryRowContext(ctx context.Context, q db.Query, args ...interface{}) pgx.Row {
+	r, err := m.query(q, args, inTx(ctx))
+	return &row{rows: r, err: err}
+}
+
+func (m *Mock) SendBatchContext(ctx context.Context, b *db.Batch) ([]db.BatchResult, error) {
+	results := make([]db.BatchResult, 0, b.Len())
+	for _, item := range b.Items() {
+		tag, err := m.exec(item.Query, item.Args, inTx(ctx))
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "batch query %s failed", item.Query.Name)
+		}
+
+		results = append(results, db.BatchResult{Query: item.Query, CommandTag: tag})
+	}
+
+	return results, nil
+}
+
+func (m *Mock) CopyFromContext(ctx context.Context, name string, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error) {
+	return m.copyFrom(copyQuery(name, table, columns), src, inTx(ctx))
+}
+
+func copyQuery(name string, table pgx.Identifier, columns []string) db.Query {
+	return db.Query{
+		Name:     name,
+		QueryRaw: fmt.Sprintf("COPY %s (%s) FROM STDIN", table.Sanitize(), strings.Join(columns, ", ")),
+	}
+}
+
+func (m *Mock) copyFrom(q db.Query, src pgx.CopyFromSource, inTx bool) (int64, error) {
+	e, err := m.next(kindCopy, q, nil, inTx)
+	if err != nil {
+		return 0, err
+	}
+
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	var n int64
+	for src.Next() {
+		if _, err = src.Values(); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, src.Err()
+}
+
+func (m *Mock) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	e, err := m.next(kindBegin, db.Query{Name: "begin"}, nil, inTx(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return &tx{mock: m}, nil
+}
+
+// Acquire pins a connection, its queries are matched against the expectations of the mock
+func (m *Mock) Acquire(ctx context.Context) (db.Conn, error) {
+	e, err := m.next(kindAcquire, db.Query{Name: "acquire"}, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return &conn{mock: m}, nil
+}
+
+func (m *Mock) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Mock) Close() {}
+
+func inTx(ctx context.Context) bool {
+	_, ok := ctx.Value(pg.TxKey).(pgx.Tx)
+	return ok
+}
@@ -0,0 +1,145 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+type user struct {
+	ID        int64      `db:"id"`
+	Name      string     `db:"name"`
+	Email     *string    `db:"email"`
+	CreatedAt time.Time  `db:"created_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+func TestMock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("scan by name", func(t *testing.T) {
+		m := New()
+		m.ExpectQuery("user.Get").
+			WithArgs(int64(1)).
+			WillReturnRows(NewRows("id", "name", "email", "created_at", "deleted_at").
+				AddRow(1, "John", "john@example.com", now, nil))
+
+		var u user
+		err := m.ScanOneContext(ctx, &u, db.Query{Name: "user.Get", QueryRaw: "SELECT * FROM users WHERE id = $1"}, int64(1))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), u.ID)
+		require.Equal(t, "John", u.Name)
+		require.Equal(t, "john@example.com", *u.Email)
+		require.Equal(t, now, u.CreatedAt)
+		require.Nil(t, u.DeletedAt)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("scan all by SQL regex", func(t *testing.T) {
+		m := New()
+		m.ExpectQuerySQL(`SELECT .* FROM users`).
+			WillReturnRows(NewRows("id", "name").AddRow(1, "John").AddRow(2, "Jane"))
+
+		var users []user
+		err := m.ScanAllContext(ctx, &users, db.Query{Name: "user.List", QueryRaw: "SELECT id, name FROM users"})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		require.Equal(t, "Jane", users[1].Name)
+	})
+
+	t.Run("query row without rows", func(t *testing.T) {
+		m := New()
+		m.ExpectQuery("user.Count").WillReturnRows(NewRows("count"))
+
+		var n int
+		err := m.QueryRowContext(ctx, db.Query{Name: "user.Count"}).Scan(&n)
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("unexpected args", func(t *testing.T) {
+		m := New()
+		m.ExpectExec("user.Delete").WithArgs(int64(1))
+
+		_, err := m.ExecContext(ctx, db.Query{Name: "user.Delete"}, int64(2))
+		require.Error(t, err)
+		require.Error(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("simulated error", func(t *testing.T) {
+		m := New()
+		failed := errors.New("connection refused")
+		m.ExpectExec("user.Delete").WithArgs(AnyArg()).WillReturnError(failed)
+
+		_, err := m.ExecContext(ctx, db.Query{Name: "user.Delete"}, int64(2))
+		require.ErrorIs(t, err, failed)
+	})
+
+	t.Run("transaction commit", func(t *testing.T) {
+		m := New()
+		m.ExpectBegin()
+		m.ExpectExec("user.Update").InTx(true).WillReturnResult("UPDATE 1")
+		m.ExpectCommit()
+
+		err := m.TxManager().ReadCommitted(ctx, func(ctx context.Context) error {
+			tag, err := m.ExecContext(ctx, db.Query{Name: "user.Update"})
+			require.Equal(t, int64(1), tag.RowsAffected())
+			return err
+		})
+		require.NoError(t, err)
+		require.NoError(t, m.ExpectationsWereMet())
+
+		var kinds []string
+		for _, c := range m.Calls() {
+			kinds = append(kinds, c.Kind)
+		}
+		require.Equal(t, []string{"begin", "exec", "commit"}, kinds)
+	})
+
+	t.Run("transaction rollback", func(t *testing.T) {
+		m := New()
+		m.ExpectBegin()
+		m.ExpectExec("user.Update").WillReturnError(errors.New("failed"))
+		m.ExpectRollback()
+
+		err := m.TxManager().ReadCommitted(ctx, func(ctx context.Context) error {
+			_, err := m.ExecContext(ctx, db.Query{Name: "user.Update"})
+			return err
+		})
+		require.Error(t, err)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		m := New()
+		m.ExpectExec("user.Create").WithArgs("John").WillReturnResult("INSERT 0 1")
+		m.ExpectExec("user.Rename").WillReturnError(errors.New("failed"))
+
+		b := &db.Batch{}
+		b.Queue(db.Query{Name: "user.Create"}, "John")
+		b.Queue(db.Query{Name: "user.Rename"}, "Jane")
+
+		_, err := m.SendBatchContext(ctx, b)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "batch query user.Rename failed")
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("unordered expectations", func(t *testing.T) {
+		m := New()
+		m.MatchExpectationsInOrder(false)
+		m.ExpectExec("first")
+		m.ExpectExec("second")
+
+		_, err := m.ExecContext(ctx, db.Query{Name: "second"})
+		require.NoError(t, err)
+		_, err = m.ExecContext(ctx, db.Query{Name: "first"})
+		require.NoError(t, err)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+}
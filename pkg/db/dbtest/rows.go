@@ -0,0 +1,204 @@
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// Rows are canned query results, they can be scanned into structs with ScanOneContext and ScanAllContext
+type Rows struct {
+	columns []string
+	values  [][]interface{}
+}
+
+// NewRows creates empty results with the given column names
+func NewRows(columns ...string) *Rows {
+	return &Rows{columns: columns}
+}
+
+// AddRow adds a row, values must be in the column order
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	if len(values) != len(r.columns) {
+		panic(fmt.Sprintf("dbtest: row has %d values, expected %d", len(values), len(r.columns)))
+	}
+
+	r.values = append(r.values, values)
+	return r
+}
+
+func (r *Rows) pgxRows() *rows {
+	if r == nil {
+		return &rows{pos: -1}
+	}
+
+	return &rows{columns: r.columns, values: r.values, pos: -1}
+}
+
+// rows implements pgx.Rows over canned values
+type rows struct {
+	columns []string
+	values  [][]interface{}
+	pos     int
+	closed  bool
+	err     error
+}
+
+func (r *rows) Close() {
+	r.closed = true
+}
+
+func (r *rows) Err() error {
+	return r.err
+}
+
+func (r *rows) CommandTag() pgconn.CommandTag {
+	return pgconn.CommandTag(fmt.Sprintf("SELECT %d", len(r.values)))
+}
+
+func (r *rows) FieldDescriptions() []pgproto3.FieldDescription {
+	fields := make([]pgproto3.FieldDescription, len(r.columns))
+	for i, c := range r.columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(c)}
+	}
+
+	return fields
+}
+
+func (r *rows) Next() bool {
+	if r.closed || r.err != nil {
+		return false
+	}
+
+	r.pos++
+	if r.pos >= len(r.values) {
+		r.closed = true
+		return false
+	}
+
+	return true
+}
+
+func (r *rows) Scan(dest ...interface{}) error {
+	if r.pos < 0 || r.pos >= len(r.values) {
+		return fmt.Errorf("dbtest: scan called without a current row")
+	}
+
+	row := r.values[r.pos]
+	if len(dest) != len(row) {
+		return fmt.Errorf("dbtest: scan expected %d destinations, got %d", len(row), len(dest))
+	}
+
+	for i, d := range dest {
+		if err := assign(d, row[i]); err != nil {
+			return fmt.Errorf("dbtest: can't scan column %s: %w", r.columns[i], err)
+		}
+	}
+
+	return nil
+}
+
+func (r *rows) Values() ([]interface{}, error) {
+	if r.pos < 0 || r.pos >= len(r.values) {
+		return nil, fmt.Errorf("dbtest: values called without a current row")
+	}
+
+	return r.values[r.pos], nil
+}
+
+func (r *rows) RawValues() [][]byte {
+	return nil
+}
+
+// row implements pgx.Row over the first canned row
+type row struct {
+	rows *rows
+	err  error
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if !r.rows.Next() {
+		return pgx.ErrNoRows
+	}
+	defer r.rows.Close()
+
+	return r.rows.Scan(dest...)
+}
+
+// assign stores value into the pointer dest, converting between compatible types
+func assign(dest, value interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination %T is not a pointer", dest)
+	}
+
+	return assignValue(dv.Elem(), value)
+}
+
+func assignValue(target reflect.Value, value interface{}) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	if target.Kind() == reflect.Interface {
+		target.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		if target.Kind() != reflect.Ptr {
+			v = v.Elem()
+		}
+	}
+
+	if target.Kind() == reflect.Ptr && v.Kind() != reflect.Ptr {
+		elem := reflect.New(target.Type().Elem())
+		if err := assignValue(elem.Elem(), v.Interface()); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	}
+
+	switch {
+	case v.Type().AssignableTo(target.Type()):
+		target.Set(v)
+	case v.Type().ConvertibleTo(target.Type()) && convertible(v.Kind(), target.Kind()):
+		target.Set(v.Convert(target.Type()))
+	default:
+		return fmt.Errorf("can't assign %T to %s", value, target.Type())
+	}
+
+	return nil
+}
+
+// convertible rejects conversions that reflect allows but that change the meaning, like int to string
+func convertible(from, to reflect.Kind) bool {
+	numeric := func(k reflect.Kind) bool {
+		return k >= reflect.Int && k <= reflect.Float64
+	}
+
+	if numeric(from) || numeric(to) {
+		return numeric(from) && numeric(to)
+	}
+
+	return true
+}
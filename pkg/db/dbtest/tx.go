@@ -0,0 +1,110 @@
+package dbtest
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// tx implements pgx.Tx on top of the mock, records commit and rollback
+type tx struct {
+	mock   *Mock
+	closed bool
+}
+
+func (t *tx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return nil, ErrNotSupported
+}
+
+func (t *tx) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	return ErrNotSupported
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	return t.finish(kindCommit)
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	return t.finish(kindRollback)
+}
+
+func (t *tx) finish(k kind) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+
+	e, err := t.mock.next(k, db.Query{Name: string(k)}, nil, true)
+	if err != nil {
+		return err
+	}
+
+	return e.err
+}
+
+func (t *tx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return t.mock.copyFrom(copyQuery("", tableName, columnNames), rowSrc, true)
+}
+
+func (t *tx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return errBatchResults{}
+}
+
+func (t *tx) LargeObjects() pgx.LargeObjects {
+	return pgx.LargeObjects{}
+}
+
+func (t *tx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	return nil, ErrNotSupported
+}
+
+func (t *tx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return t.mock.exec(db.Query{QueryRaw: sql}, arguments, true)
+}
+
+func (t *tx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	r, err := t.mock.query(db.Query{QueryRaw: sql}, args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (t *tx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	r, err := t.mock.query(db.Query{QueryRaw: sql}, args, true)
+	return &row{rows: r, err: err}
+}
+
+func (t *tx) QueryFunc(ctx context.Context, sql string, args []interface{}, scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, ErrNotSupported
+}
+
+func (t *tx) Conn() *pgx.Conn {
+	return nil
+}
+
+// errBatchResults is returned for batches sent directly on the transaction
+type errBatchResults struct{}
+
+func (errBatchResults) Exec() (pgconn.CommandTag, error) {
+	return nil, ErrNotSupported
+}
+
+func (errBatchResults) Query() (pgx.Rows, error) {
+	return nil, ErrNotSupported
+}
+
+func (errBatchResults) QueryRow() pgx.Row {
+	return &row{err: ErrNotSupported}
+}
+
+func (errBatchResults) QueryFunc(scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, ErrNotSupported
+}
+
+func (errBatchResults) Close() error {
+	return ErrNotSupported
+}
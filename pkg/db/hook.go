@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// Operation names passed to hooks in QueryEvent
+const (
+	OpExec     = "exec"
+	OpQuery    = "query"
+	OpQueryRow = "query_row"
+	OpScanOne  = "scan_one"
+	OpScanAll  = "scan_all"
+	OpCursor   = "cursor"
+	OpBatch    = "batch"
+	OpCopyFrom = "copy_from"
+)
+
+// QueryEvent describes a single DB operation.
+// Before receives the query, args and operation; After additionally receives the execution results.
+type QueryEvent struct {
+	Operation string
+	Query     Query
+	Args      []interface{}
+	InTx      bool
+	StartTime time.Time
+
+	// Filled in before After is called
+	Duration     time.Duration
+	Err          error
+	RowsAffected int64
+	CommandTag   pgconn.CommandTag
+}
+
+// Hook is called around every DB operation.
+// Before may return a derived context that is passed to the operation and to After.
+// After may replace e.Err, the resulting error is returned to the caller.
+type Hook interface {
+	Before(ctx context.Context, e *QueryEvent) context.Context
+	After(ctx context.Context, e *QueryEvent)
+}
+
+// HookFuncs adapts plain functions to the Hook interface, nil functions are skipped
+type HookFuncs struct {
+	BeforeFunc func(ctx context.Context, e *QueryEvent) context.Context
+	AfterFunc  func(ctx context.Context, e *QueryEvent)
+}
+
+func (h HookFuncs) Before(ctx context.Context, e *QueryEvent) context.Context {
+	if h.BeforeFunc == nil {
+		return ctx
+	}
+
+	return h.BeforeFunc(ctx, e)
+}
+
+func (h HookFuncs) After(ctx context.Context, e *QueryEvent) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(ctx, e)
+	}
+}
+
+// Hooks composes several hooks into one.
+// Before callbacks run in order, After callbacks run in reverse order, like nested middleware.
+type Hooks []Hook
+
+func (hs Hooks) Before(ctx context.Context, e *QueryEvent) context.Context {
+	for _, h := range hs {
+		ctx = h.Before(ctx, e)
+	}
+
+	return ctx
+}
+
+func (hs Hooks) After(ctx context.Context, e *QueryEvent) {
+	for i := len(hs) - 1; i >= 0; i-- {
+		hs[i].After(ctx, e)
+	}
+}
+
+// ChainHooks combines hooks into a single Hook, nil hooks are skipped
+func ChainHooks(hooks ...Hook) Hook {
+	chain := make(Hooks, 0, len(hooks))
+	for _, h := range hooks {
+		if h != nil {
+			chain = append(chain, h)
+		}
+	}
+
+	return chain
+}
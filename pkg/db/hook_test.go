@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string) Hook {
+		return HookFuncs{
+			BeforeFunc: func(ctx context.Context, e *QueryEvent) context.Context {
+				calls = append(calls, "before "+name)
+				return ctx
+			},
+			AfterFunc: func(ctx context.Context, e *QueryEvent) {
+				calls = append(calls, "after "+name)
+			},
+		}
+	}
+
+	replaced := errors.New("replaced")
+	chain := ChainHooks(hook("first"), nil, hook("second"), HookFuncs{
+		AfterFunc: func(ctx context.Context, e *QueryEvent) {
+			e.Err = replaced
+		},
+	})
+
+	ctx := context.Background()
+	e := &QueryEvent{Query: Query{Name: "test"}, Err: errors.New("original")}
+	ctx = chain.Before(ctx, e)
+	chain.After(ctx, e)
+
+	require.Equal(t, []string{"before first", "before second", "after second", "after first"}, calls)
+	require.ErrorIs(t, e.Err, replaced)
+}
@@ -0,0 +1,123 @@
+// Package fields maps struct fields to columns using db tags the same way scany does:
+// the tag name before the comma is the column, untagged fields are converted to snake case,
+// "-" skips the field and untagged embedded structs are flattened.
+// Options after the comma, like db:"id,readonly", are kept for the callers.
+package fields
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Field is a struct field mapped to a column
+type Field struct {
+	Column  string
+	Index   []int
+	Type    reflect.Type
+	Options []string
+}
+
+// HasOption reports whether the db tag of the field has the option
+func (f Field) HasOption(name string) bool {
+	for _, o := range f.Options {
+		if o == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Value returns the field of struct value v, v may be a pointer to a struct
+func (f Field) Value(v reflect.Value) reflect.Value {
+	return reflect.Indirect(v).FieldByIndex(f.Index)
+}
+
+var cache sync.Map // reflect.Type -> []Field
+
+// Of returns the mapped fields of struct type t in declaration order, t may be a pointer to a struct
+func Of(t reflect.Type) []Field {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if cached, ok := cache.Load(t); ok {
+		return cached.([]Field)
+	}
+
+	var result []Field
+	if t.Kind() == reflect.Struct {
+		result = collect(t, nil)
+	}
+
+	cache.Store(t, result)
+	return result
+}
+
+// Lookup returns the field mapped to column
+func Lookup(t reflect.Type, column string) (Field, bool) {
+	for _, f := range Of(t) {
+		if f.Column == column {
+			return f, true
+		}
+	}
+
+	return Field{}, false
+}
+
+func collect(t reflect.Type, index []int) []Field {
+	var result []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, hasTag := sf.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct {
+			result = append(result, collect(sf.Type, idx)...)
+			continue
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		column := parts[0]
+		if column == "" {
+			column = SnakeCase(sf.Name)
+		}
+
+		result = append(result, Field{
+			Column:  column,
+			Index:   idx,
+			Type:    sf.Type,
+			Options: parts[1:],
+		})
+	}
+
+	return result
+}
+
+// SnakeCase converts a Go field name to a column name, for example UserID to user_id
+func SnakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package fields
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type audit struct {
+	CreatedAt time.Time `db:"created_at,readonly"`
+	UpdatedAt time.Time
+}
+
+type user struct {
+	ID        int64 `db:"id,omit,readonly"`
+	Name      string
+	AvatarURL string
+	Secret    string `db:"-"`
+	hidden    string
+	audit
+}
+
+func TestOf(t *testing.T) {
+	fs := Of(reflect.TypeOf(&user{}))
+
+	columns := make([]string, 0, len(fs))
+	for _, f := range fs {
+		columns = append(columns, f.Column)
+	}
+	require.Equal(t, []string{"id", "name", "avatar_url", "created_at", "updated_at"}, columns)
+
+	id, ok := Lookup(reflect.TypeOf(user{}), "id")
+	require.True(t, ok)
+	require.True(t, id.HasOption("omit"))
+	require.True(t, id.HasOption("readonly"))
+
+	createdAt, ok := Lookup(reflect.TypeOf(user{}), "created_at")
+	require.True(t, ok)
+	require.Equal(t, []int{5, 0}, createdAt.Index)
+
+	now := time.Now()
+	u := user{ID: 7, audit: audit{CreatedAt: now}}
+	require.Equal(t, now, createdAt.Value(reflect.ValueOf(&u)).Interface())
+	require.Equal(t, int64(7), id.Value(reflect.ValueOf(u)).Interface())
+
+	_, ok = Lookup(reflect.TypeOf(user{}), "secret")
+	require.False(t, ok)
+}
+
+func TestSnakeCase(t *testing.T) {
+	require.Equal(t, "user_id", SnakeCase("UserID"))
+	require.Equal(t, "http_server", SnakeCase("HTTPServer"))
+	require.Equal(t, "name", SnakeCase("Name"))
+	require.Equal(t, "address2_line", SnakeCase("Address2Line"))
+}
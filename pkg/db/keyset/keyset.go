@@ -0,0 +1,255 @@
+// Package keyset implements keyset (seek) pagination over squirrel selects.
+// The next page starts after the sort values of the last row, which are returned as an opaque page token
+// signed with HMAC, so clients can't forge positions.
+package keyset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/internal/fields"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// ErrInvalidToken is returned for page tokens that are malformed, tampered with or issued for another ordering
+var ErrInvalidToken = sys.NewError("invalid page token", codes.InvalidArgument)
+
+// Direction is the sort direction of a column
+type Direction bool
+
+// Sort directions
+const (
+	Ascending  Direction = false
+	Descending Direction = true
+)
+
+// Column is an ordering column. Name is used in SQL and may be qualified, like u.created_at;
+// Field is the db tag of the struct field holding its value and defaults to Name without the qualifier.
+// The columns must not be nullable and together must be unique, usually the last one is the primary key.
+type Column struct {
+	Name      string
+	Field     string
+	Direction Direction
+}
+
+// Asc orders by name ascending
+func Asc(name string) Column {
+	return Column{Name: name, Direction: Ascending}
+}
+
+// Desc orders by name descending
+func Desc(name string) Column {
+	return Column{Name: name, Direction: Descending}
+}
+
+// Page is a single page of items, NextToken is empty on the last page
+type Page[T any] struct {
+	Items     []T
+	NextToken string
+}
+
+// Paginator fetches pages of T ordered by columns
+type Paginator[T any] struct {
+	secret  []byte
+	columns []Column
+	fields  []fields.Field
+	sameDir bool
+}
+
+// New creates a paginator, secret is the HMAC key used to sign page tokens
+func New[T any](secret []byte, columns ...Column) (*Paginator[T], error) {
+	if len(secret) == 0 {
+		return nil, errors.New("keyset: empty secret")
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("keyset: no ordering columns")
+	}
+
+	p := &Paginator[T]{
+		secret:  secret,
+		columns: append([]Column(nil), columns...),
+		sameDir: true,
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for i, c := range columns {
+		if c.Field == "" {
+			c.Field = c.Name[strings.LastIndex(c.Name, ".")+1:]
+			p.columns[i] = c
+		}
+
+		f, ok := fields.Lookup(t, c.Field)
+		if !ok {
+			return nil, errors.Errorf("keyset: %s has no field for column %s", t, c.Field)
+		}
+
+		p.fields = append(p.fields, f)
+		if c.Direction != columns[0].Direction {
+			p.sameDir = false
+		}
+	}
+
+	return p, nil
+}
+
+// Fetch returns up to limit items after the position encoded in token, an empty token starts from the beginning.
+// The base select must not have its own ORDER BY or LIMIT.
+func (p *Paginator[T]) Fetch(ctx context.Context, e db.NamedExecer, name string, base sq.SelectBuilder, token string, limit uint64) (Page[T], error) {
+	if limit == 0 {
+		return Page[T]{}, errors.New("keyset: limit must be positive")
+	}
+
+	b, err := p.Apply(base, token, limit+1)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	q, args, err := db.ToQuery(name, b)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	items, err := db.Select[T](ctx, e, q, args...)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: items}
+	if uint64(len(items)) > limit {
+		page.Items = items[:limit]
+		if page.NextToken, err = p.Token(page.Items[limit-1]); err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// Apply adds the keyset predicate for token, the ordering and the limit to the select
+func (p *Paginator[T]) Apply(b sq.SelectBuilder, token string, limit uint64) (sq.SelectBuilder, error) {
+	if token != "" {
+		values, err := p.decode(token)
+		if err != nil {
+			return b, err
+		}
+		b = b.Where(p.predicate(values))
+	}
+
+	orderBy := make([]string, 0, len(p.columns))
+	for _, c := range p.columns {
+		if c.Direction == Descending {
+			orderBy = append(orderBy, c.Name+" DESC")
+		} else {
+			orderBy = append(orderBy, c.Name+" ASC")
+		}
+	}
+
+	return b.OrderBy(orderBy...).Limit(limit), nil
+}
+
+// predicate selects rows after values: a row comparison when all directions match,
+// otherwise (a > $1) OR (a = $1 AND b < $2) ...
+func (p *Paginator[T]) predicate(values []interface{}) sq.Sqlizer {
+	if p.sameDir {
+		names := make([]string, len(p.columns))
+		for i, c := range p.columns {
+			names[i] = c.Name
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		return sq.Expr(fmt.Sprintf("(%s) %s (%s)", strings.Join(names, ", "), op(p.columns[0].Direction), placeholders), values...)
+	}
+
+	or := make(sq.Or, 0, len(p.columns))
+	for i, c := range p.columns {
+		and := make(sq.And, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, sq.Eq{p.columns[j].Name: values[j]})
+		}
+		and = append(and, sq.Expr(fmt.Sprintf("%s %s ?", c.Name, op(c.Direction)), values[i]))
+		or = append(or, and)
+	}
+
+	return or
+}
+
+func op(d Direction) string {
+	if d == Descending {
+		return "<"
+	}
+
+	return ">"
+}
+
+// Token returns the page token that starts after item
+func (p *Paginator[T]) Token(item T) (string, error) {
+	v := reflect.ValueOf(item)
+	values := make([]interface{}, len(p.fields))
+	for i, f := range p.fields {
+		values[i] = f.Value(v).Interface()
+	}
+
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "keyset: can't encode page token")
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(p.sign(payload)), nil
+}
+
+// decode verifies the token and decodes the values into the types of the ordering fields
+func (p *Paginator[T]) decode(token string) ([]interface{}, error) {
+	enc := base64.RawURLEncoding
+
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac, err := enc.DecodeString(encMAC)
+	if err != nil || !hmac.Equal(mac, p.sign(payload)) {
+		return nil, ErrInvalidToken
+	}
+
+	var raw []json.RawMessage
+	if err = json.Unmarshal(payload, &raw); err != nil || len(raw) != len(p.fields) {
+		return nil, ErrInvalidToken
+	}
+
+	values := make([]interface{}, len(raw))
+	for i, f := range p.fields {
+		v := reflect.New(f.Type)
+		if err = json.Unmarshal(raw[i], v.Interface()); err != nil {
+			return nil, ErrInvalidToken
+		}
+		values[i] = v.Elem().Interface()
+	}
+
+	return values, nil
+}
+
+// sign signs the payload together with the ordering, so tokens can't be reused with another ordering
+func (p *Paginator[T]) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, p.secret)
+	for _, c := range p.columns {
+		fmt.Fprintf(h, "%s:%t;", c.Name, c.Direction)
+	}
+	h.Write(payload)
+
+	return h.Sum(nil)
+}
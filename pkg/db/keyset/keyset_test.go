@@ -0,0 +1,106 @@
+package keyset
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+type order struct {
+	ID        int64     `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	Total     int64     `db:"total"`
+}
+
+var secret = []byte("test-secret")
+
+func TestPaginator(t *testing.T) {
+	ctx := context.Background()
+	base := sq.Select("o.id", "o.created_at", "o.total").From("orders o").Where(sq.Eq{"o.user_id": 7})
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("pages", func(t *testing.T) {
+		p, err := New[order](secret, Desc("o.created_at"), Desc("o.id"))
+		require.NoError(t, err)
+
+		m := dbtest.New()
+		m.ExpectQuerySQL(`^SELECT o.id, o.created_at, o.total FROM orders o WHERE o.user_id = \$1 ORDER BY o.created_at DESC, o.id DESC LIMIT 3$`).
+			WithArgs(7).
+			WillReturnRows(dbtest.NewRows("id", "created_at", "total").
+				AddRow(int64(3), createdAt, int64(30)).
+				AddRow(int64(2), createdAt, int64(20)).
+				AddRow(int64(1), createdAt, int64(10)))
+		m.ExpectQuerySQL(`WHERE o.user_id = \$1 AND \(o.created_at, o.id\) < \(\$2, \$3\) ORDER BY o.created_at DESC, o.id DESC LIMIT 3$`).
+			WithArgs(7, createdAt, int64(2)).
+			WillReturnRows(dbtest.NewRows("id", "created_at", "total").
+				AddRow(int64(1), createdAt, int64(10)))
+
+		page, err := p.Fetch(ctx, m, "order.List", base, "", 2)
+		require.NoError(t, err)
+		require.Len(t, page.Items, 2)
+		require.NotEmpty(t, page.NextToken)
+
+		page, err = p.Fetch(ctx, m, "order.List", base, page.NextToken, 2)
+		require.NoError(t, err)
+		require.Equal(t, []order{{ID: 1, CreatedAt: createdAt, Total: 10}}, page.Items)
+		require.Empty(t, page.NextToken)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("mixed directions", func(t *testing.T) {
+		p, err := New[order](secret, Desc("o.total"), Asc("o.id"))
+		require.NoError(t, err)
+
+		token, err := p.Token(order{ID: 5, Total: 50})
+		require.NoError(t, err)
+
+		b, err := p.Apply(base, token, 11)
+		require.NoError(t, err)
+
+		sql, args, err := b.PlaceholderFormat(sq.Dollar).ToSql()
+		require.NoError(t, err)
+		require.Equal(t, "SELECT o.id, o.created_at, o.total FROM orders o WHERE o.user_id = $1 AND "+
+			"((o.total < $2) OR (o.total = $3 AND o.id > $4)) ORDER BY o.total DESC, o.id ASC LIMIT 11", sql)
+		require.Equal(t, []interface{}{7, int64(50), int64(50), int64(5)}, args)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		p, err := New[order](secret, Asc("id"))
+		require.NoError(t, err)
+
+		token, err := p.Token(order{ID: 5})
+		require.NoError(t, err)
+
+		other, err := New[order](secret, Desc("id"))
+		require.NoError(t, err)
+		forged, err := New[order]([]byte("another-secret"), Asc("id"))
+		require.NoError(t, err)
+		forgedToken, err := forged.Token(order{ID: 1000})
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			p     *Paginator[order]
+			token string
+		}{
+			{p, "garbage"},
+			{p, token[:len(token)-2]},
+			{p, forgedToken},
+			{other, token},
+		} {
+			_, err = tc.p.Apply(base, tc.token, 10)
+			require.ErrorIs(t, err, ErrInvalidToken)
+			require.Equal(t, codes.InvalidArgument, sys.GetError(err).Code())
+		}
+	})
+
+	t.Run("unknown column", func(t *testing.T) {
+		_, err := New[order](secret, Asc("o.updated_at"))
+		require.Error(t, err)
+	})
+}
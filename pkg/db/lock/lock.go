@@ -0,0 +1,184 @@
+package lock
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/pg"
+)
+
+const unlockTimeout = 5 * time.Second
+
+// ErrNoTransaction is returned when a transaction scoped lock is taken outside of a transaction
+var ErrNoTransaction = errors.New("transaction scoped lock requires a transaction in context")
+
+// Key derives an advisory lock key from a name
+func Key(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// TryLockTx tries to take a transaction scoped lock without waiting.
+// The lock is released automatically when the transaction in ctx finishes.
+func TryLockTx(ctx context.Context, d db.QueryExecer, name string) (bool, error) {
+	if _, ok := ctx.Value(pg.TxKey).(pgx.Tx); !ok {
+		return false, ErrNoTransaction
+	}
+
+	var locked bool
+	err := d.QueryRowContext(ctx, db.Query{
+		Name:     "lock.try_advisory_xact_lock",
+		QueryRaw: "SELECT pg_try_advisory_xact_lock($1)",
+	}, Key(name)).Scan(&locked)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't take lock %s", name)
+	}
+
+	return locked, nil
+}
+
+// LockTx takes a transaction scoped lock, waiting until it is available or ctx is done.
+// The client default timeout doesn't apply to the wait.
+// The lock is released automatically when the transaction in ctx finishes.
+func LockTx(ctx context.Context, d db.QueryExecer, name string) error {
+	if _, ok := ctx.Value(pg.TxKey).(pgx.Tx); !ok {
+		return ErrNoTransaction
+	}
+
+	_, err := d.ExecContext(ctx, db.Query{
+		Name:     "lock.advisory_xact_lock",
+		QueryRaw: "SELECT pg_advisory_xact_lock($1)",
+		Timeout:  db.NoTimeout,
+	}, Key(name))
+
+	return errors.Wrapf(err, "can't take lock %s", name)
+}
+
+// SessionLock is a session scoped advisory lock.
+// It pins one pooled connection until Unlock is called or the context passed to TryLock or Lock is done.
+type SessionLock struct {
+	name string
+	key  int64
+	conn db.Conn
+
+	once     sync.Once
+	released chan struct{}
+	err      error
+}
+
+// TryLock tries to take a session scoped lock without waiting, nil is returned if the lock is held by someone else
+func TryLock(ctx context.Context, d db.Acquirer, name string) (*SessionLock, error) {
+	conn, err := d.Acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't acquire connection")
+	}
+
+	key := Key(name)
+
+	var locked bool
+	if err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, errors.Wrapf(err, "can't take lock %s", name)
+	}
+
+	if !locked {
+		conn.Release()
+		return nil, nil
+	}
+
+	return newLock(ctx, name, key, conn), nil
+}
+
+// Lock takes a session scoped lock, waiting until it is available or ctx is done
+func Lock(ctx context.Context, d db.Acquirer, name string) (*SessionLock, error) {
+	conn, err := d.Acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't acquire connection")
+	}
+
+	key := Key(name)
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		conn.Release()
+		return nil, errors.Wrapf(err, "can't take lock %s", name)
+	}
+
+	return newLock(ctx, name, key, conn), nil
+}
+
+// TryDo runs fn only if the session scoped lock can be taken, reporting whether fn was run.
+// The lock is released when fn returns. Useful for work that only one replica should do.
+func TryDo(ctx context.Context, d db.Acquirer, name string, fn func(ctx context.Context) error) (bool, error) {
+	l, err := TryLock(ctx, d, name)
+	if err != nil || l == nil {
+		return false, err
+	}
+
+	err = fn(ctx)
+	if errUnlock := l.Unlock(); errUnlock != nil && err == nil {
+		err = errUnlock
+	}
+
+	return true, err
+}
+
+func newLock(ctx context.Context, name string, key int64, conn db.Conn) *SessionLock {
+	l := &SessionLock{
+		name:     name,
+		key:      key,
+		conn:     conn,
+		released: make(chan struct{}),
+	}
+
+	// release the lock when ctx is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = l.Unlock()
+		case <-l.released:
+		}
+	}()
+
+	return l
+}
+
+// Name returns the lock name
+func (l *SessionLock) Name() string {
+	return l.name
+}
+
+// Done returns a channel that is closed when the lock is released
+func (l *SessionLock) Done() <-chan struct{} {
+	return l.released
+}
+
+// Unlock releases the lock and returns the pinned connection to the pool, it is safe to call several times
+func (l *SessionLock) Unlock() error {
+	l.once.Do(func() {
+		defer close(l.released)
+
+		ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+		defer cancel()
+
+		var unlocked bool
+		err := l.conn.QueryRow(ctx, "SELECT pg_advisory_unlock($1)", l.key).Scan(&unlocked)
+		if err != nil {
+			// the session state is unknown, so the connection must not be reused
+			_ = l.conn.Close(ctx)
+			l.err = errors.Wrapf(err, "can't release lock %s", l.name)
+			return
+		}
+
+		if !unlocked {
+			l.err = errors.Errorf("lock %s was not held", l.name)
+		}
+		l.conn.Release()
+	})
+
+	return l.err
+}
@@ -0,0 +1,191 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+)
+
+func TestKey(t *testing.T) {
+	require.Equal(t, Key("cron.cleanup"), Key("cron.cleanup"))
+	require.NotEqual(t, Key("cron.cleanup"), Key("cron.report"))
+}
+
+func TestTxLockRequiresTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := TryLockTx(ctx, nil, "cron.cleanup")
+	require.ErrorIs(t, err, ErrNoTransaction)
+
+	err = LockTx(ctx, nil, "cron.cleanup")
+	require.ErrorIs(t, err, ErrNoTransaction)
+}
+
+func TestLockTxWithoutTimeout(t *testing.T) {
+	m := dbtest.New()
+	m.ExpectBegin()
+	m.ExpectExec("lock.advisory_xact_lock").WithArgs(Key("cron.cleanup")).InTx(true)
+	m.ExpectCommit()
+
+	err := m.TxManager().ReadCommitted(context.Background(), func(ctx context.Context) error {
+		return LockTx(ctx, m, "cron.cleanup")
+	})
+	require.NoError(t, err)
+	require.NoError(t, m.ExpectationsWereMet())
+
+	// waiting for the lock must not be cut by the client default timeout
+	require.Equal(t, db.NoTimeout, m.Calls()[1].Query.Timeout)
+}
+
+func TestTryLock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("taken", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectQuerySQL(`pg_try_advisory_lock`).WithArgs(Key("cron.cleanup")).
+			WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+		m.ExpectQuerySQL(`pg_advisory_unlock`).WithArgs(Key("cron.cleanup")).
+			WillReturnRows(dbtest.NewRows("unlocked").AddRow(true))
+		m.ExpectRelease()
+
+		l, err := TryLock(ctx, m, "cron.cleanup")
+		require.NoError(t, err)
+		require.NotNil(t, l)
+		require.Equal(t, "cron.cleanup", l.Name())
+
+		require.NoError(t, l.Unlock())
+		require.NoError(t, l.Unlock())
+		<-l.Done()
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("held by someone else", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectQuerySQL(`pg_try_advisory_lock`).WillReturnRows(dbtest.NewRows("locked").AddRow(false))
+		m.ExpectRelease()
+
+		l, err := TryLock(ctx, m, "cron.cleanup")
+		require.NoError(t, err)
+		require.Nil(t, l)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("acquire error", func(t *testing.T) {
+		m := dbtest.New()
+		acquireErr := errors.New("pool closed")
+		m.ExpectAcquire().WillReturnError(acquireErr)
+
+		_, err := TryLock(ctx, m, "cron.cleanup")
+		require.ErrorIs(t, err, acquireErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("released when ctx is done", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectExecSQL(`pg_advisory_lock`).WithArgs(Key("cron.cleanup"))
+		m.ExpectQuerySQL(`pg_advisory_unlock`).WillReturnRows(dbtest.NewRows("unlocked").AddRow(true))
+		m.ExpectRelease()
+
+		ctx, cancel := context.WithCancel(ctx)
+		l, err := Lock(ctx, m, "cron.cleanup")
+		require.NoError(t, err)
+
+		cancel()
+		select {
+		case <-l.Done():
+		case <-time.After(time.Second):
+			t.Fatal("lock was not released")
+		}
+		require.NoError(t, l.Unlock())
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("lock error releases the connection", func(t *testing.T) {
+		m := dbtest.New()
+		lockErr := errors.New("canceling statement due to user request")
+		m.ExpectAcquire()
+		m.ExpectExecSQL(`pg_advisory_lock`).WillReturnError(lockErr)
+		m.ExpectRelease()
+
+		_, err := Lock(ctx, m, "cron.cleanup")
+		require.ErrorIs(t, err, lockErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("unlock error closes the connection", func(t *testing.T) {
+		m := dbtest.New()
+		unlockErr := errors.New("connection reset")
+		m.ExpectAcquire()
+		m.ExpectExecSQL(`pg_advisory_lock`)
+		m.ExpectQuerySQL(`pg_advisory_unlock`).WillReturnError(unlockErr)
+		m.ExpectClose()
+
+		l, err := Lock(ctx, m, "cron.cleanup")
+		require.NoError(t, err)
+		require.ErrorIs(t, l.Unlock(), unlockErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("not held", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectExecSQL(`pg_advisory_lock`)
+		m.ExpectQuerySQL(`pg_advisory_unlock`).WillReturnRows(dbtest.NewRows("unlocked").AddRow(false))
+		m.ExpectRelease()
+
+		l, err := Lock(ctx, m, "cron.cleanup")
+		require.NoError(t, err)
+		require.EqualError(t, l.Unlock(), "lock cron.cleanup was not held")
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestTryDo(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("runs while holding the lock", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectQuerySQL(`pg_try_advisory_lock`).WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+		m.ExpectExec("cron.cleanup")
+		m.ExpectQuerySQL(`pg_advisory_unlock`).WillReturnRows(dbtest.NewRows("unlocked").AddRow(true))
+		m.ExpectRelease()
+
+		fnErr := errors.New("cleanup failed")
+		ran, err := TryDo(ctx, m, "cron.cleanup", func(ctx context.Context) error {
+			_, err := m.ExecContext(ctx, db.Query{Name: "cron.cleanup"})
+			require.NoError(t, err)
+			return fnErr
+		})
+		require.True(t, ran)
+		require.ErrorIs(t, err, fnErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("skipped when held", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectAcquire()
+		m.ExpectQuerySQL(`pg_try_advisory_lock`).WillReturnRows(dbtest.NewRows("locked").AddRow(false))
+		m.ExpectRelease()
+
+		ran, err := TryDo(ctx, m, "cron.cleanup", func(ctx context.Context) error {
+			t.Fatal("fn must not run")
+			return nil
+		})
+		require.False(t, ran)
+		require.NoError(t, err)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+}
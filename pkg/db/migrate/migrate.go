@@ -0,0 +1,376 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/transaction"
+)
+
+const (
+	defaultTable = "schema_migrations"
+	// defaultLockKey is the advisory lock key shared by all migrators of a database
+	defaultLockKey int64 = 0x6d6967726174
+)
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration file was changed
+	ErrChecksumMismatch = errors.New("applied migration checksum mismatch")
+	// ErrUnknownVersion is returned when a migration is not found in the source
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// LogFunc defines the signature for the logging function
+type LogFunc func(msg string, fields ...interface{})
+
+// Status describes a migration and whether it is applied
+type Status struct {
+	Version   uint64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Changed is set when the applied checksum differs from the file
+	Changed bool
+	// Missing is set when the migration is applied but its file is not found
+	Missing bool
+}
+
+type applied struct {
+	Version   uint64    `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Migrator applies versioned up/down SQL migrations read from a file system, for example an embed.FS.
+// Applied versions are recorded with checksums in a table and a Postgres advisory lock
+// prevents parallel migrators from racing, so the pool needs at least two connections.
+type Migrator struct {
+	db         db.DB
+	txManager  db.TxManager
+	fsys       fs.FS
+	dir        string
+	table      string
+	lockKey    int64
+	logFunc    LogFunc
+	migrations []Migration
+}
+
+// Option configures the migrator
+type Option func(*Migrator)
+
+// WithDir sets the directory inside the file system that contains migrations, "." by default
+func WithDir(dir string) Option {
+	return func(m *Migrator) {
+		m.dir = dir
+	}
+}
+
+// WithTable sets the table that records applied versions, "schema_migrations" by default
+func WithTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// WithLockKey sets the advisory lock key
+func WithLockKey(key int64) Option {
+	return func(m *Migrator) {
+		m.lockKey = key
+	}
+}
+
+// WithLogFunc sets the logging function
+func WithLogFunc(logFunc LogFunc) Option {
+	return func(m *Migrator) {
+		m.logFunc = logFunc
+	}
+}
+
+// New reads migrations from fsys and creates a migrator
+func New(d db.DB, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	m := &Migrator{
+		db:        d,
+		txManager: transaction.NewTransactionManager(d),
+		fsys:      fsys,
+		dir:       ".",
+		table:     defaultTable,
+		lockKey:   defaultLockKey,
+		logFunc:   func(msg string, fields ...interface{}) {}, // Use a no-op log function by default
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	migrations, err := load(m.fsys, m.dir)
+	if err != nil {
+		return nil, err
+	}
+	m.migrations = migrations
+
+	return m, nil
+}
+
+// Migrations returns all migrations found in the source
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context, done map[uint64]applied) error {
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+
+			if err := m.up(ctx, mig); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the n most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(ctx context.Context, done map[uint64]applied) error {
+		versions := appliedVersions(done)
+		for i := len(versions) - 1; i >= 0 && n > 0; i, n = i-1, n-1 {
+			if err := m.downVersion(ctx, versions[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Goto migrates up or down until exactly the migrations up to version are applied.
+// Version 0 rolls back all migrations.
+func (m *Migrator) Goto(ctx context.Context, version uint64) error {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return errors.Wrapf(ErrUnknownVersion, "version %d", version)
+		}
+	}
+
+	return m.withLock(ctx, func(ctx context.Context, done map[uint64]applied) error {
+		versions := appliedVersions(done)
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i] <= version {
+				break
+			}
+
+			if err := m.downVersion(ctx, versions[i]); err != nil {
+				return err
+			}
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+
+			if err := m.up(ctx, mig); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status returns all known migrations, including applied versions that are missing from the source
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name}
+		if a, ok := done[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+			s.Changed = a.Checksum != mig.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+
+	for _, a := range done {
+		if _, ok := m.find(a.Version); !ok {
+			statuses = append(statuses, Status{
+				Version:   a.Version,
+				Name:      a.Name,
+				Applied:   true,
+				AppliedAt: a.AppliedAt,
+				Missing:   true,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// withLock holds the advisory lock in a separate transaction while fn runs,
+// after verifying that no applied migration was changed
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context, done map[uint64]applied) error) error {
+	lockTx, err := m.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can't begin lock transaction")
+	}
+	defer func() {
+		_ = lockTx.Rollback(context.Background())
+	}()
+
+	m.logFunc("Waiting for migration lock", "key", m.lockKey)
+	if _, err = lockTx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", m.lockKey); err != nil {
+		return errors.Wrap(err, "can't acquire migration lock")
+	}
+
+	if err = m.createTable(ctx); err != nil {
+		return err
+	}
+
+	done, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if a, ok := done[mig.Version]; ok && a.Checksum != mig.Checksum {
+			return errors.Wrapf(ErrChecksumMismatch, "migration %d_%s", mig.Version, mig.Name)
+		}
+	}
+
+	return fn(ctx, done)
+}
+
+func (m *Migrator) up(ctx context.Context, mig Migration) error {
+	m.logFunc("Applying migration", "version", mig.Version, "name", mig.Name)
+
+	err := m.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		if _, err := m.db.ExecContext(ctx, db.Query{Name: m.migrationName(mig, "up"), QueryRaw: mig.Up, Timeout: db.NoTimeout}); err != nil {
+			return err
+		}
+
+		_, err := m.db.ExecContext(ctx, db.Query{
+			Name:     "migrate.insert_version",
+			QueryRaw: fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3)", m.quotedTable()),
+		}, mig.Version, mig.Name, mig.Checksum)
+		return err
+	})
+
+	return errors.Wrapf(err, "can't apply migration %d_%s", mig.Version, mig.Name)
+}
+
+func (m *Migrator) downVersion(ctx context.Context, version uint64) error {
+	mig, ok := m.find(version)
+	if !ok {
+		return errors.Wrapf(ErrUnknownVersion, "can't roll back version %d", version)
+	}
+
+	if strings.TrimSpace(mig.Down) == "" {
+		return errors.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+	}
+
+	m.logFunc("Rolling back migration", "version", mig.Version, "name", mig.Name)
+
+	err := m.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		if _, err := m.db.ExecContext(ctx, db.Query{Name: m.migrationName(mig, "down"), QueryRaw: mig.Down, Timeout: db.NoTimeout}); err != nil {
+			return err
+		}
+
+		_, err := m.db.ExecContext(ctx, db.Query{
+			Name:     "migrate.delete_version",
+			QueryRaw: fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.quotedTable()),
+		}, mig.Version)
+		return err
+	})
+
+	return errors.Wrapf(err, "can't roll back migration %d_%s", mig.Version, mig.Name)
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, db.Query{
+		Name: "migrate.create_table",
+		QueryRaw: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	checksum text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`, m.quotedTable()),
+	})
+
+	return errors.Wrap(err, "can't create migrations table")
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[uint64]applied, error) {
+	var rows []applied
+	err := m.db.ScanAllContext(ctx, &rows, db.Query{
+		Name:     "migrate.applied",
+		QueryRaw: fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", m.quotedTable()),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read applied migrations")
+	}
+
+	done := make(map[uint64]applied, len(rows))
+	for _, a := range rows {
+		done[a.Version] = a
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) find(version uint64) (Migration, bool) {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return m.migrations[i].Version >= version
+	})
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i], true
+	}
+
+	return Migration{}, false
+}
+
+func (m *Migrator) migrationName(mig Migration, direction string) string {
+	return fmt.Sprintf("migrate.%d_%s.%s", mig.Version, mig.Name, direction)
+}
+
+func (m *Migrator) quotedTable() string {
+	return pgx.Identifier(strings.Split(m.table, ".")).Sanitize()
+}
+
+func appliedVersions(done map[uint64]applied) []uint64 {
+	versions := make([]uint64, 0, len(done))
+	for v := range done {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	return versions
+}
@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+)
+
+var testFS = fstest.MapFS{
+	"0001_create_users.up.sql":    {Data: []byte("CREATE TABLE users (id bigserial);")},
+	"0001_create_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+	"0002_add_email.up.sql":       {Data: []byte("ALTER TABLE users ADD email text;")},
+	"0002_add_email.down.sql":     {Data: []byte("ALTER TABLE users DROP email;")},
+	"0003_create_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id bigserial);")},
+	"0003_create_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+}
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *dbtest.Mock) {
+	mock := dbtest.New()
+	m, err := New(mock, fsys)
+	require.NoError(t, err)
+
+	return m, mock
+}
+
+// expectLock expects the lock transaction and the applied versions query, versions are applied with their current checksum
+func expectLock(m *Migrator, mock *dbtest.Mock, versions ...uint64) {
+	rows := dbtest.NewRows("version", "name", "checksum", "applied_at")
+	for _, v := range versions {
+		mig, _ := m.find(v)
+		rows.AddRow(mig.Version, mig.Name, mig.Checksum, time.Now())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExecSQL(`pg_advisory_xact_lock`).WithArgs(defaultLockKey).InTx(true)
+	mock.ExpectExec("migrate.create_table")
+	mock.ExpectQuery("migrate.applied").WillReturnRows(rows)
+}
+
+func expectUp(m *Migrator, mock *dbtest.Mock, version uint64) {
+	mig, _ := m.find(version)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(m.migrationName(mig, "up")).InTx(true)
+	mock.ExpectExec("migrate.insert_version").WithArgs(mig.Version, mig.Name, mig.Checksum).InTx(true)
+	mock.ExpectCommit()
+}
+
+func expectDown(m *Migrator, mock *dbtest.Mock, version uint64) {
+	mig, _ := m.find(version)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(m.migrationName(mig, "down")).InTx(true)
+	mock.ExpectExec("migrate.delete_version").WithArgs(mig.Version).InTx(true)
+	mock.ExpectCommit()
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("up applies pending migrations in order", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+		expectLock(m, mock, 1)
+		expectUp(m, mock, 2)
+		expectUp(m, mock, 3)
+		mock.ExpectRollback()
+
+		require.NoError(t, m.Up(ctx))
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		// migrations can run longer than the client default timeout
+		for _, c := range mock.Calls() {
+			if strings.HasSuffix(c.Query.Name, ".up") {
+				require.Equal(t, db.NoTimeout, c.Query.Timeout, c.Query.Name)
+			}
+		}
+	})
+
+	t.Run("changed migration is refused", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+		mock.ExpectBegin()
+		mock.ExpectExecSQL(`pg_advisory_xact_lock`)
+		mock.ExpectExec("migrate.create_table")
+		mock.ExpectQuery("migrate.applied").WillReturnRows(dbtest.NewRows("version", "name", "checksum", "applied_at").
+			AddRow(uint64(1), "create_users", "changed", time.Now()))
+		mock.ExpectRollback()
+
+		err := m.Up(ctx)
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("down rolls back the latest migrations first", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+		expectLock(m, mock, 1, 2, 3)
+		expectDown(m, mock, 3)
+		expectDown(m, mock, 2)
+		mock.ExpectRollback()
+
+		require.NoError(t, m.Down(ctx, 2))
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		for _, c := range mock.Calls() {
+			if strings.HasSuffix(c.Query.Name, ".down") {
+				require.Equal(t, db.NoTimeout, c.Query.Timeout, c.Query.Name)
+			}
+		}
+	})
+
+	t.Run("down without down file", func(t *testing.T) {
+		m, mock := newTestMigrator(t, fstest.MapFS{
+			"0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id bigserial);")},
+		})
+		expectLock(m, mock, 1)
+		mock.ExpectRollback()
+
+		err := m.Down(ctx, 1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "has no down file")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("goto up", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+		expectLock(m, mock, 1)
+		expectUp(m, mock, 2)
+		mock.ExpectRollback()
+
+		require.NoError(t, m.Goto(ctx, 2))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("goto down", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+		expectLock(m, mock, 1, 2, 3)
+		expectDown(m, mock, 3)
+		expectDown(m, mock, 2)
+		mock.ExpectRollback()
+
+		require.NoError(t, m.Goto(ctx, 1))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("goto unknown version", func(t *testing.T) {
+		m, mock := newTestMigrator(t, testFS)
+
+		require.ErrorIs(t, m.Goto(ctx, 42), ErrUnknownVersion)
+		require.Empty(t, mock.Calls())
+	})
+}
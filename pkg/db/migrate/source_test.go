@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("migrations are sorted and paired", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"migrations/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text;")},
+			"migrations/0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+			"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigserial);")},
+			"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+			"migrations/README.md":                  {Data: []byte("ignored")},
+		}
+
+		migrations, err := load(fsys, "migrations")
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+
+		require.Equal(t, uint64(1), migrations[0].Version)
+		require.Equal(t, "create_users", migrations[0].Name)
+		require.Equal(t, "DROP TABLE users;", migrations[0].Down)
+		require.Equal(t, checksum("CREATE TABLE users (id bigserial);", "DROP TABLE users;"), migrations[0].Checksum)
+
+		require.Equal(t, uint64(2), migrations[1].Version)
+		require.Equal(t, "add_email", migrations[1].Name)
+	})
+
+	t.Run("down file without up file", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		}
+
+		_, err := load(fsys, ".")
+		require.Error(t, err)
+	})
+
+	t.Run("same version with different names", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_users.up.sql":  {Data: []byte("CREATE TABLE users (id bigserial);")},
+			"0001_create_orders.up.sql": {Data: []byte("CREATE TABLE orders (id bigserial);")},
+		}
+
+		_, err := load(fsys, ".")
+		require.Error(t, err)
+	})
+
+	t.Run("zero version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0000_init.up.sql": {Data: []byte("SELECT 1;")},
+		}
+
+		_, err := load(fsys, ".")
+		require.Error(t, err)
+	})
+}
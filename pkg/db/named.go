@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db/internal/fields"
+)
+
+// namedQuery is a query with named placeholders rewritten to $n, names[i] is bound to $i+1
+type namedQuery struct {
+	sql   string
+	names []string
+	// positional is set when the query also has $n parameters
+	positional bool
+}
+
+// maxNamedCache bounds the number of cached rewrites, queries beyond it are parsed on every call
+const maxNamedCache = 1024
+
+var (
+	namedCache     sync.Map // query text -> *namedQuery
+	namedCacheSize atomic.Int64
+)
+
+// Named rewrites :name and @name placeholders in q to $n and returns the values for them from arg,
+// a map[string]interface{} or a struct (or a pointer to one) with db tags.
+// The same name is bound to the same $n. Placeholders in string literals, quoted identifiers, comments
+// and dollar-quoted strings are kept, as are :: casts. The rewrite is cached per query text.
+func Named(q Query, arg interface{}) (Query, []interface{}, error) {
+	nq := compileNamed(q.QueryRaw)
+	if nq.positional && len(nq.names) > 0 {
+		return Query{}, nil, errors.Errorf("query %s mixes $n and named placeholders", q.Name)
+	}
+
+	args, err := bindNamed(nq.names, arg)
+	if err != nil {
+		return Query{}, nil, errors.Wrapf(err, "can't bind query %s", q.Name)
+	}
+
+	q.QueryRaw = nq.sql
+	return q, args, nil
+}
+
+// NamedExec rewrites the query with Named and executes it with ExecContext
+func NamedExec(ctx context.Context, e QueryExecer, q Query, arg interface{}) (pgconn.CommandTag, error) {
+	q, args, err := Named(q, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ExecContext(ctx, q, args...)
+}
+
+// NamedGet rewrites the query with Named and scans a single row with Get
+func NamedGet[T any](ctx context.Context, e NamedExecer, q Query, arg interface{}) (T, error) {
+	q, args, err := Named(q, arg)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return Get[T](ctx, e, q, args...)
+}
+
+// NamedSelect rewrites the query with Named and scans all rows with Select
+func NamedSelect[T any](ctx context.Context, e NamedExecer, q Query, arg interface{}) ([]T, error) {
+	q, args, err := Named(q, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return Select[T](ctx, e, q, args...)
+}
+
+func compileNamed(sql string) *namedQuery {
+	if cached, ok := namedCache.Load(sql); ok {
+		return cached.(*namedQuery)
+	}
+
+	nq := parseNamed(sql)
+	if namedCacheSize.Load() < maxNamedCache {
+		if _, loaded := namedCache.LoadOrStore(sql, nq); !loaded {
+			namedCacheSize.Add(1)
+		}
+	}
+
+	return nq
+}
+
+// parseNamed scans the query once, copying everything except named placeholders as is
+func parseNamed(sql string) *namedQuery {
+	var (
+		b          strings.Builder
+		names      []string
+		positions  = make(map[string]int)
+		brackets   int
+		positional bool
+	)
+	b.Grow(len(sql))
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			// string literal or quoted identifier, doubled quotes escape, E'' strings also escape with backslash
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			end := i + 1
+			for end < len(sql) {
+				if escapes && sql[end] == '\\' {
+					end += 2
+					continue
+				}
+				if sql[end] == c {
+					if end+1 < len(sql) && sql[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(sql))
+			b.WriteString(sql[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			b.WriteString(sql[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := blockCommentEnd(sql, i)
+			b.WriteString(sql[i:end])
+			i = end
+		case c == '$' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			positional = true
+			b.WriteByte(c)
+			i++
+		case c == '$':
+			end := dollarQuoteEnd(sql, i)
+			b.WriteString(sql[i:end])
+			i = end
+		case c == ':' && strings.HasPrefix(sql[i:], "::"):
+			b.WriteString("::")
+			i += 2
+		case c == '@' && strings.HasPrefix(sql[i:], "@@"):
+			b.WriteString("@@")
+			i += 2
+		case c == '[' || c == ']':
+			if c == '[' {
+				brackets++
+			} else if brackets > 0 {
+				brackets--
+			}
+			b.WriteByte(c)
+			i++
+		case c == ':' && brackets > 0 && isSliceColon(sql, i):
+			b.WriteByte(c)
+			i++
+		case (c == ':' || c == '@') && i+1 < len(sql) && isNameStart(sql[i+1]):
+			end := i + 2
+			for end < len(sql) && isNamePart(sql[end]) {
+				end++
+			}
+
+			name := sql[i+1 : end]
+			pos, ok := positions[name]
+			if !ok {
+				names = append(names, name)
+				pos = len(names)
+				positions[name] = pos
+			}
+
+			b.WriteString("$" + strconv.Itoa(pos))
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return &namedQuery{sql: b.String(), names: names, positional: positional}
+}
+
+// isSliceColon reports whether the colon at i inside brackets follows an operand, like in arr[lo:hi],
+// and not the opening bracket or a comma, like in arr[:idx] or ARRAY[:a, :b]
+func isSliceColon(sql string, i int) bool {
+	prev := strings.TrimRight(sql[:i], " \t\r\n")
+	if prev == "" {
+		return false
+	}
+
+	last := prev[len(prev)-1]
+	return last != '[' && last != ','
+}
+
+// blockCommentEnd returns the position after the block comment starting at i, block comments nest
+func blockCommentEnd(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+
+	return len(sql)
+}
+
+// dollarQuoteEnd returns the position after the dollar-quoted string starting at i,
+// or after the $ itself when it is a positional parameter or not a quote
+func dollarQuoteEnd(sql string, i int) int {
+	end := i + 1
+	for end < len(sql) && isNamePart(sql[end]) && !(end == i+1 && sql[end] >= '0' && sql[end] <= '9') {
+		end++
+	}
+	if end >= len(sql) || sql[end] != '$' {
+		return i + 1
+	}
+
+	tag := sql[i : end+1]
+	closing := strings.Index(sql[end+1:], tag)
+	if closing < 0 {
+		return len(sql)
+	}
+
+	return end + 1 + closing + len(tag)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// bindNamed returns the values of names from a map or struct
+func bindNamed(names []string, arg interface{}) ([]interface{}, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	if m, ok := arg.(map[string]interface{}); ok {
+		args := make([]interface{}, len(names))
+		for i, name := range names {
+			v, ok := m[name]
+			if !ok {
+				return nil, errors.Errorf("no value for :%s", name)
+			}
+			args[i] = v
+		}
+
+		return args, nil
+	}
+
+	rv := reflect.ValueOf(arg)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.Errorf("named arguments must be a map[string]interface{} or a struct, got %T", arg)
+	}
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		f, ok := fields.Lookup(rv.Type(), name)
+		if !ok {
+			return nil, errors.Errorf("no value for :%s in %s", name, rv.Type())
+		}
+		args[i] = f.Value(rv).Interface()
+	}
+
+	return args, nil
+}
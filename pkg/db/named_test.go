@@ -0,0 +1,134 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamed(t *testing.T) {
+	t.Run("rewrite", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			sql   string
+			want  string
+			names []string
+		}{
+			{
+				name:  "colon and at placeholders",
+				sql:   "SELECT * FROM users WHERE id = :id AND org_id = @org_id AND (parent_id = :id OR :id IS NULL)",
+				want:  "SELECT * FROM users WHERE id = $1 AND org_id = $2 AND (parent_id = $1 OR $1 IS NULL)",
+				names: []string{"id", "org_id"},
+			},
+			{
+				name:  "casts",
+				sql:   "SELECT :created_at::timestamptz, tags::text[] FROM t",
+				want:  "SELECT $1::timestamptz, tags::text[] FROM t",
+				names: []string{"created_at"},
+			},
+			{
+				name:  "literals and identifiers",
+				sql:   `SELECT ':no', 'it''s :no', E'\':no', ":no" FROM t WHERE a = :yes`,
+				want:  `SELECT ':no', 'it''s :no', E'\':no', ":no" FROM t WHERE a = $1`,
+				names: []string{"yes"},
+			},
+			{
+				name:  "comments",
+				sql:   "SELECT 1 -- :no\nFROM t /* :no /* nested :no */ :no */ WHERE a = :yes",
+				want:  "SELECT 1 -- :no\nFROM t /* :no /* nested :no */ :no */ WHERE a = $1",
+				names: []string{"yes"},
+			},
+			{
+				name:  "dollar quotes",
+				sql:   "SELECT $$ :no $$, $fn$ @no $fn$, :yes",
+				want:  "SELECT $$ :no $$, $fn$ @no $fn$, $1",
+				names: []string{"yes"},
+			},
+			{
+				name:  "array slices",
+				sql:   "SELECT arr[lo:hi], arr[1:2], arr[@lo:@hi], m[1][lo : hi] FROM t WHERE id = :id",
+				want:  "SELECT arr[lo:hi], arr[1:2], arr[$1:$2], m[1][lo : hi] FROM t WHERE id = $3",
+				names: []string{"lo", "hi", "id"},
+			},
+			{
+				name:  "placeholders in brackets",
+				sql:   "SELECT ARRAY[:a,:b], ARRAY[:a, :c], arr[:idx], arr[ :idx] FROM t",
+				want:  "SELECT ARRAY[$1,$2], ARRAY[$1, $3], arr[$4], arr[ $4] FROM t",
+				names: []string{"a", "b", "c", "idx"},
+			},
+			{
+				name: "operators",
+				sql:  "SELECT tags @> ARRAY['a'], doc @@ query, arr[1:2] FROM t",
+				want: "SELECT tags @> ARRAY['a'], doc @@ query, arr[1:2] FROM t",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				nq := parseNamed(tt.sql)
+				require.Equal(t, tt.want, nq.sql)
+				require.Equal(t, tt.names, nq.names)
+			})
+		}
+	})
+
+	t.Run("bind map", func(t *testing.T) {
+		q, args, err := Named(Query{Name: "user.Find", QueryRaw: "SELECT * FROM users WHERE name = :name AND age > :age"},
+			map[string]interface{}{"name": "John", "age": 18})
+		require.NoError(t, err)
+		require.Equal(t, "user.Find", q.Name)
+		require.Equal(t, "SELECT * FROM users WHERE name = $1 AND age > $2", q.QueryRaw)
+		require.Equal(t, []interface{}{"John", 18}, args)
+	})
+
+	t.Run("bind struct", func(t *testing.T) {
+		type filter struct {
+			Name   string `db:"name"`
+			MinAge int    `db:"min_age"`
+		}
+
+		_, args, err := Named(Query{QueryRaw: "SELECT * FROM users WHERE name = :name AND age > :min_age"},
+			&filter{Name: "John", MinAge: 18})
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"John", 18}, args)
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		_, _, err := Named(Query{Name: "user.Find", QueryRaw: "SELECT * FROM users WHERE id = :id"}, map[string]interface{}{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user.Find")
+
+		_, _, err = Named(Query{QueryRaw: "SELECT * FROM users WHERE id = :id"}, 42)
+		require.Error(t, err)
+	})
+
+	t.Run("mixed placeholders", func(t *testing.T) {
+		_, _, err := Named(Query{Name: "user.Find", QueryRaw: "SELECT * FROM users WHERE id = $1 AND name = :name"},
+			map[string]interface{}{"name": "John"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user.Find")
+
+		q, args, err := Named(Query{QueryRaw: "SELECT * FROM users WHERE id = $1"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, "SELECT * FROM users WHERE id = $1", q.QueryRaw)
+		require.Empty(t, args)
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		sql := "SELECT :cached"
+		require.Same(t, compileNamed(sql), compileNamed(sql))
+	})
+
+	t.Run("cache is bounded", func(t *testing.T) {
+		for i := 0; i < maxNamedCache+10; i++ {
+			compileNamed("SELECT :id + " + strconv.Itoa(i))
+		}
+		require.Equal(t, int64(maxNamedCache), namedCacheSize.Load())
+
+		sql := "SELECT :not_cached"
+		nq := compileNamed(sql)
+		require.Equal(t, "SELECT $1", nq.sql)
+		require.NotSame(t, nq, compileNamed(sql))
+	})
+}
@@ -0,0 +1,98 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultTable           = "outbox"
+	defaultBatchSize       = 100
+	defaultPollInterval    = time.Second
+	defaultMaxAttempts     = 10
+	defaultMinBackoff      = time.Second
+	defaultMaxBackoff      = 5 * time.Minute
+	defaultRetention       = 7 * 24 * time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+// Option configures the outbox and the relay
+type Option func(*options)
+
+type options struct {
+	table           string
+	batchSize       int
+	pollInterval    time.Duration
+	maxAttempts     int
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+	retention       time.Duration
+	cleanupInterval time.Duration
+	errFunc         func(ctx context.Context, err error)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		table:           defaultTable,
+		batchSize:       defaultBatchSize,
+		pollInterval:    defaultPollInterval,
+		maxAttempts:     defaultMaxAttempts,
+		minBackoff:      defaultMinBackoff,
+		maxBackoff:      defaultMaxBackoff,
+		retention:       defaultRetention,
+		cleanupInterval: defaultCleanupInterval,
+		errFunc:         func(ctx context.Context, err error) {}, // Use a no-op error function by default
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithTable sets the outbox table name, "outbox" by default
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// WithBatchSize sets how many events the relay claims at once
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+// WithPollInterval sets how long the relay waits when there is nothing to publish
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = d
+	}
+}
+
+// WithRetry sets the maximum publish attempts and the exponential backoff between them.
+// Events that exhausted all attempts are marked failed and no longer block their aggregate key.
+func WithRetry(maxAttempts int, minBackoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = maxAttempts
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithCleanup sets how long sent and failed events are kept and how often they are deleted
+func WithCleanup(retention, interval time.Duration) Option {
+	return func(o *options) {
+		o.retention = retention
+		o.cleanupInterval = interval
+	}
+}
+
+// WithErrorFunc sets the function that receives relay errors
+func WithErrorFunc(f func(ctx context.Context, err error)) Option {
+	return func(o *options) {
+		o.errFunc = f
+	}
+}
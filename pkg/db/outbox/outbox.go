@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/pg"
+)
+
+// ErrNoTransaction is returned when events are added outside of a transaction
+var ErrNoTransaction = errors.New("outbox events must be added inside a transaction")
+
+// Event is a domain event stored in the outbox table
+type Event struct {
+	ID           int64             `db:"id"`
+	AggregateKey string            `db:"aggregate_key"`
+	Topic        string            `db:"topic"`
+	Payload      []byte            `db:"payload"`
+	Headers      map[string]string `db:"headers"`
+	Attempts     int               `db:"attempts"`
+	CreatedAt    time.Time         `db:"created_at"`
+}
+
+// Publisher delivers events to a message broker
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, e Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// Schema returns the DDL for the outbox table, to be used in migrations
+func Schema(table string) string {
+	t := quote(table)
+	index := pgx.Identifier{strings.ReplaceAll(table, ".", "_") + "_pending_idx"}.Sanitize()
+
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id bigserial PRIMARY KEY,
+	aggregate_key text NOT NULL,
+	topic text NOT NULL,
+	payload bytea NOT NULL,
+	headers jsonb NOT NULL DEFAULT '{}',
+	attempts int NOT NULL DEFAULT 0,
+	last_error text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	next_attempt_at timestamptz NOT NULL DEFAULT now(),
+	sent_at timestamptz,
+	failed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS %s ON %s (aggregate_key, id) WHERE sent_at IS NULL AND failed_at IS NULL;
+`, t, index, t)
+}
+
+// Outbox writes events to the outbox table in the caller's transaction
+type Outbox struct {
+	db    db.QueryExecer
+	table string
+}
+
+// New creates an outbox writer, only WithTable applies to it
+func New(d db.QueryExecer, opts ...Option) *Outbox {
+	o := newOptions(opts)
+	return &Outbox{db: d, table: o.table}
+}
+
+// Add stores events in the outbox table. It must be called inside a transaction,
+// so events are committed or rolled back together with the domain changes.
+func (o *Outbox) Add(ctx context.Context, events ...Event) error {
+	if _, ok := ctx.Value(pg.TxKey).(pgx.Tx); !ok {
+		return ErrNoTransaction
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	builder := sq.Insert(quote(o.table)).Columns("aggregate_key", "topic", "payload", "headers")
+	for _, e := range events {
+		headers := e.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		builder = builder.Values(e.AggregateKey, e.Topic, e.Payload, headers)
+	}
+
+	_, err := db.ExecBuilder(ctx, o.db, "outbox.add", builder)
+	return errors.Wrap(err, "can't add outbox events")
+}
+
+func quote(table string) string {
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// Relay claims unsent events and hands them to a Publisher.
+// Events of the same aggregate key are published strictly in insertion order:
+// only the oldest pending event of every key can be claimed, so several relays can run in parallel.
+// Delivery is at-least-once, publishers must tolerate duplicates.
+// Relay queries run without the client default timeout, the relay is bounded by the context passed to Run.
+type Relay struct {
+	db        db.DB
+	txManager db.TxManager
+	publisher Publisher
+	opts      *options
+}
+
+// NewRelay creates a relay worker
+func NewRelay(d db.DB, txManager db.TxManager, publisher Publisher, opts ...Option) *Relay {
+	return &Relay{
+		db:        d,
+		txManager: txManager,
+		publisher: publisher,
+		opts:      newOptions(opts),
+	}
+}
+
+// Run publishes events until ctx is done, periodically deleting old sent and failed events
+func (r *Relay) Run(ctx context.Context) error {
+	lastCleanup := time.Time{}
+	for {
+		if time.Since(lastCleanup) >= r.opts.cleanupInterval {
+			if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				r.opts.errFunc(ctx, err)
+			}
+			lastCleanup = time.Now()
+		}
+
+		n, err := r.ProcessBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.opts.errFunc(ctx, err)
+		}
+
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.opts.pollInterval):
+		}
+	}
+}
+
+// ProcessBatch claims and publishes one batch of events, returning the number of claimed events
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	var claimed int
+	err := r.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		var events []Event
+		err := r.db.ScanAllContext(ctx, &events, db.Query{
+			Name: "outbox.claim",
+			QueryRaw: fmt.Sprintf(`SELECT o.id, o.aggregate_key, o.topic, o.payload, o.headers, o.attempts, o.created_at
+FROM %[1]s o
+WHERE o.sent_at IS NULL AND o.failed_at IS NULL AND o.next_attempt_at <= now()
+	AND NOT EXISTS (
+		SELECT 1 FROM %[1]s p
+		WHERE p.aggregate_key = o.aggregate_key AND p.id < o.id AND p.sent_at IS NULL AND p.failed_at IS NULL
+	)
+ORDER BY o.id
+LIMIT $1
+FOR UPDATE SKIP LOCKED`, quote(r.opts.table)),
+			Timeout: db.NoTimeout,
+		}, r.opts.batchSize)
+		if err != nil {
+			return errors.Wrap(err, "can't claim outbox events")
+		}
+
+		claimed = len(events)
+		for _, e := range events {
+			if err = r.publish(ctx, e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+func (r *Relay) publish(ctx context.Context, e Event) error {
+	pubErr := r.publisher.Publish(ctx, e)
+	if pubErr == nil {
+		_, err := r.db.ExecContext(ctx, db.Query{
+			Name:     "outbox.mark_sent",
+			QueryRaw: fmt.Sprintf("UPDATE %s SET sent_at = now(), attempts = attempts + 1 WHERE id = $1", quote(r.opts.table)),
+			Timeout:  db.NoTimeout,
+		}, e.ID)
+
+		return errors.Wrapf(err, "can't mark outbox event %d sent", e.ID)
+	}
+
+	r.opts.errFunc(ctx, errors.Wrapf(pubErr, "can't publish outbox event %d", e.ID))
+
+	attempts := e.Attempts + 1
+	if attempts >= r.opts.maxAttempts {
+		_, err := r.db.ExecContext(ctx, db.Query{
+			Name:     "outbox.mark_failed",
+			QueryRaw: fmt.Sprintf("UPDATE %s SET failed_at = now(), attempts = $2, last_error = $3 WHERE id = $1", quote(r.opts.table)),
+			Timeout:  db.NoTimeout,
+		}, e.ID, attempts, pubErr.Error())
+
+		return errors.Wrapf(err, "can't mark outbox event %d failed", e.ID)
+	}
+
+	_, err := r.db.ExecContext(ctx, db.Query{
+		Name:     "outbox.retry_later",
+		QueryRaw: fmt.Sprintf("UPDATE %s SET next_attempt_at = now() + make_interval(secs => $2), attempts = $3, last_error = $4 WHERE id = $1", quote(r.opts.table)),
+		Timeout:  db.NoTimeout,
+	}, e.ID, r.backoff(attempts).Seconds(), attempts, pubErr.Error())
+
+	return errors.Wrapf(err, "can't reschedule outbox event %d", e.ID)
+}
+
+// backoff returns the delay before the next attempt, doubling after every failed attempt
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.opts.minBackoff
+	for i := 1; i < attempts && d < r.opts.maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > r.opts.maxBackoff {
+		d = r.opts.maxBackoff
+	}
+
+	return d
+}
+
+// Cleanup deletes sent and failed events older than the retention period
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	tag, err := r.db.ExecContext(ctx, db.Query{
+		Name: "outbox.cleanup",
+		QueryRaw: fmt.Sprintf(`DELETE FROM %s
+WHERE (sent_at IS NOT NULL AND sent_at < now() - make_interval(secs => $1))
+	OR (failed_at IS NOT NULL AND failed_at < now() - make_interval(secs => $1))`, quote(r.opts.table)),
+		Timeout: db.NoTimeout,
+	}, r.opts.retention.Seconds())
+	if err != nil {
+		return 0, errors.Wrap(err, "can't clean up outbox events")
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+)
+
+func TestRelayBackoff(t *testing.T) {
+	r := NewRelay(nil, nil, nil, WithRetry(5, time.Second, 10*time.Second))
+
+	require.Equal(t, time.Second, r.backoff(1))
+	require.Equal(t, 2*time.Second, r.backoff(2))
+	require.Equal(t, 4*time.Second, r.backoff(3))
+	require.Equal(t, 8*time.Second, r.backoff(4))
+	require.Equal(t, 10*time.Second, r.backoff(5))
+	require.Equal(t, 10*time.Second, r.backoff(50))
+}
+
+func TestAddRequiresTransaction(t *testing.T) {
+	o := New(nil)
+
+	err := o.Add(context.Background(), Event{AggregateKey: "user:1", Topic: "user.created"})
+	require.ErrorIs(t, err, ErrNoTransaction)
+}
+
+func TestRelayQueriesWithoutTimeout(t *testing.T) {
+	ctx := context.Background()
+	m := dbtest.New()
+	m.ExpectBegin()
+	m.ExpectQuery("outbox.claim").WillReturnRows(dbtest.NewRows("id"))
+	m.ExpectCommit()
+	m.ExpectExec("outbox.cleanup").WillReturnResult("DELETE 0")
+
+	r := NewRelay(m, m.TxManager(), nil)
+	_, err := r.ProcessBatch(ctx)
+	require.NoError(t, err)
+	_, err = r.Cleanup(ctx)
+	require.NoError(t, err)
+	require.NoError(t, m.ExpectationsWereMet())
+
+	for _, c := range m.Calls() {
+		if c.Kind != "begin" && c.Kind != "commit" {
+			require.Equal(t, db.NoTimeout, c.Query.Timeout, c.Query.Name)
+		}
+	}
+}
+
+// eventRows returns claim rows for the events
+func eventRows(events ...Event) *dbtest.Rows {
+	rows := dbtest.NewRows("id", "aggregate_key", "topic", "payload", "headers", "attempts", "created_at")
+	for _, e := range events {
+		rows.AddRow(e.ID, e.AggregateKey, e.Topic, e.Payload, e.Headers, e.Attempts, e.CreatedAt)
+	}
+
+	return rows
+}
+
+func TestRelayProcessBatch(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pubErr := errors.New("broker unavailable")
+
+	t.Run("claims oldest pending event of every key", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectBegin()
+		m.ExpectQuerySQL(`(?s)p\.aggregate_key = o\.aggregate_key AND p\.id < o\.id.*ORDER BY o\.id\s+LIMIT \$1\s+FOR UPDATE SKIP LOCKED$`).
+			WithArgs(25).InTx(true).WillReturnRows(eventRows())
+		m.ExpectCommit()
+
+		r := NewRelay(m, m.TxManager(), nil, WithBatchSize(25))
+		n, err := r.ProcessBatch(ctx)
+		require.NoError(t, err)
+		require.Zero(t, n)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("publishes in claim order and marks events", func(t *testing.T) {
+		events := []Event{
+			{ID: 1, AggregateKey: "user:1", Topic: "user.created", Payload: []byte(`{}`), Headers: map[string]string{"trace": "a"}, CreatedAt: createdAt},
+			{ID: 2, AggregateKey: "user:2", Topic: "user.created", Payload: []byte(`{}`), Attempts: 2, CreatedAt: createdAt},
+			{ID: 3, AggregateKey: "user:3", Topic: "user.created", Payload: []byte(`{}`), Attempts: 4, CreatedAt: createdAt},
+		}
+
+		m := dbtest.New()
+		m.ExpectBegin()
+		m.ExpectQuery("outbox.claim").InTx(true).WillReturnRows(eventRows(events...))
+		m.ExpectExec("outbox.mark_sent").WithArgs(int64(1)).InTx(true).WillReturnResult("UPDATE 1")
+		m.ExpectExec("outbox.retry_later").WithArgs(int64(2), float64(4), 3, pubErr.Error()).InTx(true).WillReturnResult("UPDATE 1")
+		m.ExpectExec("outbox.mark_failed").WithArgs(int64(3), 5, pubErr.Error()).InTx(true).WillReturnResult("UPDATE 1")
+		m.ExpectCommit()
+
+		var published []Event
+		var errs []error
+		r := NewRelay(m, m.TxManager(), PublisherFunc(func(ctx context.Context, e Event) error {
+			published = append(published, e)
+			if e.ID == 1 {
+				return nil
+			}
+			return pubErr
+		}), WithRetry(5, time.Second, time.Minute), WithErrorFunc(func(ctx context.Context, err error) {
+			errs = append(errs, err)
+		}))
+
+		n, err := r.ProcessBatch(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		require.Equal(t, events, published)
+		require.Len(t, errs, 2)
+		require.ErrorIs(t, errs[0], pubErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("rolls back when an event can't be marked", func(t *testing.T) {
+		markErr := errors.New("connection reset")
+
+		m := dbtest.New()
+		m.ExpectBegin()
+		m.ExpectQuery("outbox.claim").WillReturnRows(eventRows(
+			Event{ID: 1, AggregateKey: "user:1", CreatedAt: createdAt},
+			Event{ID: 2, AggregateKey: "user:2", CreatedAt: createdAt},
+		))
+		m.ExpectExec("outbox.mark_sent").WithArgs(int64(1)).WillReturnError(markErr)
+		m.ExpectRollback()
+
+		published := 0
+		r := NewRelay(m, m.TxManager(), PublisherFunc(func(ctx context.Context, e Event) error {
+			published++
+			return nil
+		}))
+
+		n, err := r.ProcessBatch(ctx)
+		require.ErrorIs(t, err, markErr)
+		require.Equal(t, 2, n)
+		// the claim is rolled back, so the rest of the batch is left for the next run
+		require.Equal(t, 1, published)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("claim error", func(t *testing.T) {
+		claimErr := errors.New("relation outbox does not exist")
+
+		m := dbtest.New()
+		m.ExpectBegin()
+		m.ExpectQuery("outbox.claim").WillReturnError(claimErr)
+		m.ExpectRollback()
+
+		r := NewRelay(m, m.TxManager(), nil)
+		_, err := r.ProcessBatch(ctx)
+		require.ErrorIs(t, err, claimErr)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestRelayCleanup(t *testing.T) {
+	ctx := context.Background()
+
+	m := dbtest.New()
+	m.ExpectExecSQL(`DELETE FROM "events"`).WithArgs(float64(3600)).InTx(false).WillReturnResult("DELETE 7")
+
+	r := NewRelay(m, m.TxManager(), nil, WithTable("events"), WithCleanup(time.Hour, time.Minute))
+	n, err := r.Cleanup(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), n)
+	require.NoError(t, m.ExpectationsWereMet())
+
+	cleanupErr := errors.New("permission denied")
+	m.ExpectExec("outbox.cleanup").WillReturnError(cleanupErr)
+	_, err = r.Cleanup(ctx)
+	require.ErrorIs(t, err, cleanupErr)
+}
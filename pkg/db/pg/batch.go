@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// SendBatchContext sends all queued queries in a single round trip, using the transaction from ctx if present.
+// Hooks run for every queued query. The first failed query is returned as an error wrapped with its name.
+// The batch timeout is the sum of the item timeouts, see batchTimeout.
+func (p *pg) SendBatchContext(ctx context.Context, b *db.Batch) ([]db.BatchResult, error) {
+	if p.tenancy.wraps(ctx) {
+		var results []db.BatchResult
+		err := p.inTenantTx(ctx, p.dbc, func(err error) error {
+			for _, item := range b.Items() {
+				err = p.failed(ctx, db.OpBatch, item.Query, item.Args)(err)
+			}
+			return err
+		}, func(ctx context.Context) (err error) {
+			results, err = p.SendBatchContext(ctx, b)
+			return err
+		})
+		return results, err
+	}
+
+	items := b.Items()
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	batch := &pgx.Batch{}
+	events := make([]*db.QueryEvent, len(items))
+	contexts := make([]context.Context, len(items))
+	for i, item := range items {
+		contexts[i], events[i] = p.before(ctx, db.OpBatch, item.Query, item.Args)
+		batch.Queue(item.Query.QueryRaw, item.Args...)
+	}
+
+	qctx, release, timeout, err := p.deadline(ctx, p.batchTimeout(items))
+	defer release()
+	if err != nil {
+		for i := range items {
+			_ = p.after(contexts[i], events[i], err)
+		}
+		return nil, err
+	}
+
+	var br pgx.BatchResults
+	if tx, ok := ctx.Value(TxKey).(pgx.Tx); ok {
+		br = tx.SendBatch(qctx, batch)
+	} else {
+		br = p.dbc.SendBatch(qctx, batch)
+	}
+
+	var firstErr error
+	results := make([]db.BatchResult, len(items))
+	for i, item := range items {
+		tag, err := br.Exec()
+		events[i].CommandTag = tag
+		err = p.after(contexts[i], events[i], timeoutError(ctx, item.Query, timeout, err))
+
+		results[i] = db.BatchResult{Query: item.Query, CommandTag: tag}
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "batch query %s failed", item.Query.Name)
+		}
+	}
+
+	if err := br.Close(); err != nil && firstErr == nil {
+		firstErr = errors.Wrap(err, "can't close batch")
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return results, nil
+}
+
+// batchTimeout returns a query with the timeout of the whole batch: the sum of the effective item timeouts,
+// or no timeout when any item has none
+func (p *pg) batchTimeout(items []db.BatchItem) db.Query {
+	var total time.Duration
+	for _, item := range items {
+		d := p.timeout(item.Query)
+		if d <= 0 {
+			return db.Query{Name: "batch", Timeout: -1}
+		}
+		total += d
+	}
+
+	return db.Query{Name: "batch", Timeout: total}
+}
@@ -0,0 +1,106 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// batchTx returns the results in order for a batch sent on the transaction
+type batchTx struct {
+	pgx.Tx
+	results []batchResult
+	sent    *pgx.Batch
+}
+
+type batchResult struct {
+	tag string
+	err error
+}
+
+func (tx *batchTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	tx.sent = b
+	return &fakeBatchResults{results: tx.results}
+}
+
+type fakeBatchResults struct {
+	pgx.BatchResults
+	results []batchResult
+}
+
+func (r *fakeBatchResults) Exec() (pgconn.CommandTag, error) {
+	res := r.results[0]
+	r.results = r.results[1:]
+	return pgconn.CommandTag(res.tag), res.err
+}
+
+func (r *fakeBatchResults) Close() error {
+	return nil
+}
+
+func TestSendBatch(t *testing.T) {
+	var events []db.QueryEvent
+	p := &pg{}
+	WithHooks(db.HookFuncs{
+		AfterFunc: func(ctx context.Context, e *db.QueryEvent) {
+			events = append(events, *e)
+		},
+	})(p)
+
+	b := &db.Batch{}
+	b.Queue(db.Query{Name: "user.Create", QueryRaw: "INSERT INTO users (name) VALUES ($1)"}, "John")
+	b.Queue(db.Query{Name: "user.Rename", QueryRaw: "UPDATE users SET name = $1"}, "Jane")
+	b.Queue(db.Query{Name: "user.Purge", QueryRaw: "DELETE FROM users"})
+
+	t.Run("results in order", func(t *testing.T) {
+		events = nil
+		tx := &batchTx{results: []batchResult{{tag: "INSERT 0 1"}, {tag: "UPDATE 2"}, {tag: "DELETE 3"}}}
+
+		results, err := p.SendBatchContext(MakeContextTx(context.Background(), tx), b)
+		require.NoError(t, err)
+		require.Equal(t, 3, tx.sent.Len())
+
+		require.Len(t, results, 3)
+		for i, name := range []string{"user.Create", "user.Rename", "user.Purge"} {
+			require.Equal(t, name, results[i].Query.Name)
+			require.Equal(t, int64(i+1), results[i].CommandTag.RowsAffected())
+
+			require.Equal(t, name, events[i].Query.Name)
+			require.Equal(t, db.OpBatch, events[i].Operation)
+			require.Equal(t, b.Items()[i].Args, events[i].Args)
+			require.Equal(t, int64(i+1), events[i].RowsAffected)
+			require.True(t, events[i].InTx)
+		}
+		require.Len(t, events, 3)
+	})
+
+	t.Run("first error is wrapped with the query name", func(t *testing.T) {
+		events = nil
+		errRename, errPurge := errors.New("rename failed"), errors.New("purge failed")
+		tx := &batchTx{results: []batchResult{{tag: "INSERT 0 1"}, {err: errRename}, {err: errPurge}}}
+
+		results, err := p.SendBatchContext(MakeContextTx(context.Background(), tx), b)
+		require.ErrorIs(t, err, errRename)
+		require.Contains(t, err.Error(), "batch query user.Rename failed")
+		require.Nil(t, results)
+
+		require.Len(t, events, 3)
+		require.NoError(t, events[0].Err)
+		require.Equal(t, errRename, events[1].Err)
+		require.Equal(t, errPurge, events[2].Err)
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		events = nil
+
+		results, err := p.SendBatchContext(context.Background(), &db.Batch{})
+		require.NoError(t, err)
+		require.Nil(t, results)
+		require.Empty(t, events)
+	})
+}
@@ -15,12 +15,14 @@ type pgClient struct {
 	masterDBC db.DB
 }
 
-func New(pool *pgxpool.Pool, logger *LogFunc) (db.Client, error) {
+// New creates a client around the primary pool.
+// Replicas for read-only queries can be added with WithReplicas.
+func New(pool *pgxpool.Pool, logger *LogFunc, opts ...Option) (db.Client, error) {
 	if pool == nil {
 		return nil, errors.New("pool is nil")
 	}
 
-	masterDBC := NewDB(pool, logger)
+	masterDBC := NewDB(pool, logger, opts...)
 	return &pgClient{
 		masterDBC: masterDBC,
 	}, nil
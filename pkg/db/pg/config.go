@@ -0,0 +1,319 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/closer"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// StatementCacheMode controls how pgx caches prepared statements
+type StatementCacheMode string
+
+const (
+	// StatementCachePrepare prepares and caches statements, the pgx default
+	StatementCachePrepare StatementCacheMode = "prepare"
+	// StatementCacheDescribe caches statement descriptions only, safe with PgBouncer in transaction mode
+	StatementCacheDescribe StatementCacheMode = "describe"
+	// StatementCacheNone disables the statement cache
+	StatementCacheNone StatementCacheMode = "none"
+	// StatementCacheSimple uses the simple protocol without any prepared statements
+	StatementCacheSimple StatementCacheMode = "simple"
+)
+
+const (
+	defaultPingTimeout = 5 * time.Second
+	maskedPassword     = "xxxxx"
+)
+
+// passwordRe matches the password of a keyword/value connection string, quoted or not
+var passwordRe = regexp.MustCompile(`(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s']\S*)`)
+
+// AfterConnectFunc is called for every new connection before it is added to the pool,
+// for example to register custom types or set session parameters
+type AfterConnectFunc func(ctx context.Context, conn *pgx.Conn) error
+
+// Config describes a connection pool. Either DSN or the discrete connection fields are used,
+// the remaining settings are applied on top of both.
+type Config struct {
+	DSN string
+
+	Host     string
+	Port     uint16
+	User     string
+	Password string
+	Database string
+
+	SSLMode         string
+	SSLCertFile     string
+	SSLKeyFile      string
+	SSLRootCertFile string
+
+	ApplicationName string
+	ConnectTimeout  time.Duration
+	// RuntimeParams are session parameters sent on connect, for example search_path or timezone
+	RuntimeParams map[string]string
+
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	StatementCacheMode     StatementCacheMode
+	StatementCacheCapacity int
+}
+
+type poolOptions struct {
+	afterConnect   []AfterConnectFunc
+	registerCloser bool
+	clientOpts     []Option
+}
+
+// PoolOption configures NewPool and NewFromConfig
+type PoolOption func(*poolOptions)
+
+// WithAfterConnect adds functions that are called for every new connection
+func WithAfterConnect(fns ...AfterConnectFunc) PoolOption {
+	return func(o *poolOptions) {
+		o.afterConnect = append(o.afterConnect, fns...)
+	}
+}
+
+// WithCloser registers the client Close with the closer package, used by NewFromConfig
+func WithCloser() PoolOption {
+	return func(o *poolOptions) {
+		o.registerCloser = true
+	}
+}
+
+// WithClientOptions passes options to New, used by NewFromConfig
+func WithClientOptions(opts ...Option) PoolOption {
+	return func(o *poolOptions) {
+		o.clientOpts = append(o.clientOpts, opts...)
+	}
+}
+
+func newPoolOptions(opts []PoolOption) *poolOptions {
+	o := &poolOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// ConnString builds a connection string from the config.
+// When DSN is set the connection fields (Host, Port, User, Password, Database) are ignored
+// and the remaining settings are added to it.
+func (c Config) ConnString() (string, error) {
+	var params [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			params = append(params, [2]string{key, value})
+		}
+	}
+
+	if c.DSN == "" {
+		add("host", c.Host)
+		if c.Port != 0 {
+			add("port", strconv.Itoa(int(c.Port)))
+		}
+		add("user", c.User)
+		add("password", c.Password)
+		add("dbname", c.Database)
+	}
+
+	add("sslmode", c.SSLMode)
+	add("sslcert", c.SSLCertFile)
+	add("sslkey", c.SSLKeyFile)
+	add("sslrootcert", c.SSLRootCertFile)
+	add("application_name", c.ApplicationName)
+	if c.ConnectTimeout > 0 {
+		// connect_timeout has whole seconds and 0 means no timeout, NewPool sets the exact value
+		add("connect_timeout", strconv.Itoa(int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+
+	switch c.StatementCacheMode {
+	case "":
+	case StatementCachePrepare, StatementCacheDescribe:
+		add("statement_cache_mode", string(c.StatementCacheMode))
+	case StatementCacheNone:
+		add("statement_cache_capacity", "0")
+	case StatementCacheSimple:
+		add("statement_cache_capacity", "0")
+		add("prefer_simple_protocol", "true")
+	default:
+		return "", errors.Errorf("unknown statement cache mode %q", c.StatementCacheMode)
+	}
+	if c.StatementCacheCapacity > 0 && c.StatementCacheMode != StatementCacheNone && c.StatementCacheMode != StatementCacheSimple {
+		add("statement_cache_capacity", strconv.Itoa(c.StatementCacheCapacity))
+	}
+
+	keys := make([]string, 0, len(c.RuntimeParams))
+	for k := range c.RuntimeParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, c.RuntimeParams[k])
+	}
+
+	// URL DSN: settings go to the query string
+	if strings.HasPrefix(c.DSN, "postgres://") || strings.HasPrefix(c.DSN, "postgresql://") {
+		u, err := url.Parse(c.DSN)
+		if err != nil {
+			return "", errors.Wrap(err, "can't parse DSN")
+		}
+
+		query := u.Query()
+		for _, kv := range params {
+			query.Set(kv[0], kv[1])
+		}
+		u.RawQuery = query.Encode()
+
+		return u.String(), nil
+	}
+
+	// keyword/value DSN or discrete fields
+	parts := make([]string, 0, len(params)+1)
+	if c.DSN != "" {
+		parts = append(parts, c.DSN)
+	}
+	for _, kv := range params {
+		parts = append(parts, fmt.Sprintf("%s=%s", kv[0], quoteConnValue(kv[1])))
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// quoteConnValue quotes a keyword/value connection string value
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// NewPool builds the pool from the config and pings it
+func NewPool(ctx context.Context, cfg Config, opts ...PoolOption) (*pgxpool.Pool, error) {
+	o := newPoolOptions(opts)
+
+	connString, err := cfg.ConnString()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid pg config")
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse pg config")
+	}
+
+	if cfg.ConnectTimeout > 0 {
+		poolConfig.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	if afterConnect := o.afterConnect; len(afterConnect) > 0 {
+		poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			for _, fn := range afterConnect {
+				if err := fn(ctx, conn); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create pg pool")
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, defaultPingTimeout)
+	defer cancel()
+
+	if err = pool.Ping(pingCtx); err != nil {
+		pool.Close()
+		return nil, errors.Wrap(err, "can't ping pg")
+	}
+
+	return pool, nil
+}
+
+// NewFromConfig builds and pings the pool and returns a client around it.
+// Client options are passed with WithClientOptions, WithCloser registers the client Close with the closer package.
+func NewFromConfig(ctx context.Context, cfg Config, logger *LogFunc, opts ...PoolOption) (db.Client, error) {
+	pool, err := NewPool(ctx, cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newPoolOptions(opts)
+	client, err := New(pool, logger, o.clientOpts...)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	if o.registerCloser {
+		closer.Add(client.Close)
+	}
+
+	return client, nil
+}
+
+// String returns the connection string with the password masked, safe for logging
+func (c Config) String() string {
+	if c.Password != "" {
+		c.Password = maskedPassword
+	}
+
+	connString, err := c.ConnString()
+	if err != nil {
+		return err.Error()
+	}
+
+	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		u, err := url.Parse(connString)
+		if err != nil {
+			return "invalid DSN"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), maskedPassword)
+		}
+		query := u.Query()
+		if query.Has("password") {
+			query.Set("password", maskedPassword)
+			u.RawQuery = query.Encode()
+		}
+
+		return u.String()
+	}
+
+	return passwordRe.ReplaceAllString(connString, "${1}"+maskedPassword)
+}
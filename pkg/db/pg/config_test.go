@@ -0,0 +1,86 @@
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigConnString(t *testing.T) {
+	t.Run("discrete fields", func(t *testing.T) {
+		cfg := Config{
+			Host:               "localhost",
+			Port:               5433,
+			User:               "app",
+			Password:           "it's secret",
+			Database:           "orders",
+			SSLMode:            "disable",
+			ApplicationName:    "orders-api",
+			ConnectTimeout:     3 * time.Second,
+			RuntimeParams:      map[string]string{"search_path": "app,public"},
+			StatementCacheMode: StatementCacheDescribe,
+			MaxConns:           7,
+		}
+
+		connString, err := cfg.ConnString()
+		require.NoError(t, err)
+
+		poolConfig, err := pgxpool.ParseConfig(connString)
+		require.NoError(t, err)
+
+		connConfig := poolConfig.ConnConfig
+		require.Equal(t, "localhost", connConfig.Host)
+		require.Equal(t, uint16(5433), connConfig.Port)
+		require.Equal(t, "app", connConfig.User)
+		require.Equal(t, "it's secret", connConfig.Password)
+		require.Equal(t, "orders", connConfig.Database)
+		require.Equal(t, 3*time.Second, connConfig.ConnectTimeout)
+		require.Equal(t, "orders-api", connConfig.RuntimeParams["application_name"])
+		require.Equal(t, "app,public", connConfig.RuntimeParams["search_path"])
+		require.NotContains(t, cfg.String(), "secret")
+	})
+
+	t.Run("url DSN", func(t *testing.T) {
+		cfg := Config{
+			DSN:                "postgres://app:secret@db:5432/orders",
+			ApplicationName:    "orders-api",
+			StatementCacheMode: StatementCacheSimple,
+		}
+
+		connString, err := cfg.ConnString()
+		require.NoError(t, err)
+
+		poolConfig, err := pgxpool.ParseConfig(connString)
+		require.NoError(t, err)
+		require.Equal(t, "db", poolConfig.ConnConfig.Host)
+		require.True(t, poolConfig.ConnConfig.PreferSimpleProtocol)
+		require.Nil(t, poolConfig.ConnConfig.BuildStatementCache)
+		require.Equal(t, "orders-api", poolConfig.ConnConfig.RuntimeParams["application_name"])
+		require.NotContains(t, cfg.String(), "secret")
+	})
+
+	t.Run("keyword DSN password is masked", func(t *testing.T) {
+		for _, dsn := range []string{
+			"host=db user=app password=topsecret",
+			"host=db user=app password = 'top secret' dbname=orders",
+			"postgres://app@db/orders?password=topsecret",
+		} {
+			s := Config{DSN: dsn}.String()
+			require.NotContains(t, s, "secret", dsn)
+			require.Contains(t, s, "xxxxx", dsn)
+		}
+	})
+
+	t.Run("connect timeout under a second", func(t *testing.T) {
+		connString, err := Config{Host: "db", ConnectTimeout: 300 * time.Millisecond}.ConnString()
+		require.NoError(t, err)
+		require.Contains(t, connString, "connect_timeout='1'")
+	})
+
+	t.Run("unknown cache mode", func(t *testing.T) {
+		_, err := Config{StatementCacheMode: "always"}.ConnString()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,52 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// CopyFromContext bulk loads rows from src into table using COPY FROM, inside the transaction from ctx if present.
+// The number of copied rows is reported to hooks as RowsAffected. The client default timeout applies to the whole copy.
+func (p *pg) CopyFromContext(ctx context.Context, name string, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error) {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+
+	q := db.Query{
+		Name:     name,
+		QueryRaw: fmt.Sprintf("COPY %s (%s) FROM STDIN", table.Sanitize(), strings.Join(quoted, ", ")),
+	}
+
+	if p.tenancy.wraps(ctx) {
+		var n int64
+		err := p.inTenantTx(ctx, p.dbc, p.failed(ctx, db.OpCopyFrom, q, nil), func(ctx context.Context) (err error) {
+			n, err = p.CopyFromContext(ctx, name, table, columns, src)
+			return err
+		})
+		return n, err
+	}
+	ctx, e := p.before(ctx, db.OpCopyFrom, q, nil)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	defer release()
+	if err != nil {
+		return 0, p.after(ctx, e, err)
+	}
+
+	var n int64
+	if tx, ok := ctx.Value(TxKey).(pgx.Tx); ok {
+		n, err = tx.CopyFrom(qctx, table, columns, src)
+	} else {
+		n, err = p.dbc.CopyFrom(qctx, table, columns, src)
+	}
+
+	e.RowsAffected = n
+	e.CommandTag = pgconn.CommandTag(fmt.Sprintf("COPY %d", n))
+	return n, p.after(ctx, e, timeoutError(ctx, q, timeout, err))
+}
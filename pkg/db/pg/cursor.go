@@ -0,0 +1,191 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+const (
+	// DefaultFetchSize is the number of rows fetched at once when fetchSize is not positive
+	DefaultFetchSize = 1000
+
+	cursorCloseTimeout = 5 * time.Second
+)
+
+var cursorSeq uint64
+
+// CursorContext declares a server-side cursor for the query and returns rows that FETCH fetchSize rows at a time.
+// Outside of a transaction the cursor gets its own transaction, on a replica for read-only queries,
+// which is rolled back when the rows are closed. Hooks see a single event that finishes on Close.
+// The timeout limits the whole iteration, exports usually need a negative db.Query.Timeout.
+func (p *pg) CursorContext(ctx context.Context, q db.Query, fetchSize int, args ...interface{}) (pgx.Rows, error) {
+	ctx, e := p.before(ctx, db.OpCursor, q, args)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	if err != nil {
+		release()
+		return nil, p.after(ctx, e, err)
+	}
+
+	if fetchSize <= 0 {
+		fetchSize = DefaultFetchSize
+	}
+
+	tx, inTx := ctx.Value(TxKey).(pgx.Tx)
+	if !inTx {
+		tx, err = p.begin(qctx, p.pool(ctx, q), pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			release()
+			err = timeoutError(ctx, q, timeout, err)
+			return nil, p.after(ctx, e, errors.Wrapf(err, "can't begin cursor transaction for %s", q.Name))
+		}
+	}
+
+	r := &cursorRows{
+		ctx:       qctx,
+		tx:        tx,
+		ownTx:     !inTx,
+		name:      fmt.Sprintf("db_cursor_%d", atomic.AddUint64(&cursorSeq, 1)),
+		fetchSize: fetchSize,
+		finish: func(total int64, err error) error {
+			defer release()
+			e.CommandTag = pgconn.CommandTag(fmt.Sprintf("SELECT %d", total))
+			return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
+		},
+	}
+
+	_, err = tx.Exec(qctx, fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", r.name, q.QueryRaw), args...)
+	if err == nil {
+		err = r.fetch()
+	}
+	if err != nil {
+		r.err = err
+		r.Close()
+		return nil, r.err
+	}
+
+	return r, nil
+}
+
+// cursorRows reads the cursor batch by batch, only one batch of rows is held at a time
+type cursorRows struct {
+	ctx       context.Context
+	tx        pgx.Tx
+	ownTx     bool
+	name      string
+	fetchSize int
+	finish    func(total int64, err error) error
+
+	batch      pgx.Rows
+	batchCount int
+	total      int64
+	err        error
+	closed     bool
+}
+
+func (r *cursorRows) fetch() error {
+	batch, err := r.tx.Query(r.ctx, fmt.Sprintf("FETCH FORWARD %d FROM %s", r.fetchSize, r.name))
+	if err != nil {
+		return err
+	}
+
+	r.batch = batch
+	r.batchCount = 0
+	return nil
+}
+
+func (r *cursorRows) Next() bool {
+	for !r.closed {
+		if r.batch.Next() {
+			r.batchCount++
+			r.total++
+			return true
+		}
+
+		r.batch.Close()
+		if err := r.batch.Err(); err != nil {
+			r.err = err
+			r.Close()
+			return false
+		}
+
+		// a short batch means the cursor is exhausted
+		if r.batchCount < r.fetchSize {
+			r.Close()
+			return false
+		}
+
+		if err := r.fetch(); err != nil {
+			r.err = err
+			r.Close()
+			return false
+		}
+	}
+
+	return false
+}
+
+func (r *cursorRows) Close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	if r.batch != nil {
+		r.batch.Close()
+		if r.err == nil {
+			r.err = r.batch.Err()
+		}
+	}
+
+	// the context may already be canceled, the cursor still has to be released
+	ctx, cancel := context.WithTimeout(context.Background(), cursorCloseTimeout)
+	defer cancel()
+
+	if r.ownTx {
+		// rolling back the transaction closes the cursor as well
+		_ = r.tx.Rollback(ctx)
+	} else if r.err == nil {
+		if _, err := r.tx.Exec(ctx, "CLOSE "+r.name); err != nil {
+			r.err = errors.Wrapf(err, "can't close cursor %s", r.name)
+		}
+	}
+
+	r.err = r.finish(r.total, r.err)
+}
+
+func (r *cursorRows) Err() error {
+	if r.closed || r.batch == nil {
+		return r.err
+	}
+
+	return r.batch.Err()
+}
+
+func (r *cursorRows) CommandTag() pgconn.CommandTag {
+	return pgconn.CommandTag(fmt.Sprintf("SELECT %d", r.total))
+}
+
+func (r *cursorRows) FieldDescriptions() []pgproto3.FieldDescription {
+	return r.batch.FieldDescriptions()
+}
+
+func (r *cursorRows) Scan(dest ...interface{}) error {
+	return r.batch.Scan(dest...)
+}
+
+func (r *cursorRows) Values() ([]interface{}, error) {
+	return r.batch.Values()
+}
+
+func (r *cursorRows) RawValues() [][]byte {
+	return r.batch.RawValues()
+}
@@ -0,0 +1,219 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// cursorTx serves FETCH from values and records the statements
+type cursorTx struct {
+	pgx.Tx
+	values     []int64
+	fetchErr   error
+	statements []string
+	rolledBack bool
+}
+
+func (tx *cursorTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.statements = append(tx.statements, sql)
+	return pgconn.CommandTag("SELECT 0"), nil
+}
+
+func (tx *cursorTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	tx.statements = append(tx.statements, sql)
+	if tx.fetchErr != nil {
+		return nil, tx.fetchErr
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(sql, "FETCH FORWARD %d", &n); err != nil {
+		return nil, err
+	}
+	if n > len(tx.values) {
+		n = len(tx.values)
+	}
+
+	batch := tx.values[:n]
+	tx.values = tx.values[n:]
+	return &fakeRows{values: batch}, nil
+}
+
+func (tx *cursorTx) Rollback(ctx context.Context) error {
+	tx.rolledBack = true
+	return nil
+}
+
+// fetches returns the number of FETCH statements
+func (tx *cursorTx) fetches() int {
+	n := 0
+	for _, s := range tx.statements {
+		if strings.HasPrefix(s, "FETCH") {
+			n++
+		}
+	}
+
+	return n
+}
+
+func readCursor(t *testing.T, rows pgx.Rows) []int64 {
+	var got []int64
+	for rows.Next() {
+		var v int64
+		require.NoError(t, rows.Scan(&v))
+		got = append(got, v)
+	}
+
+	return got
+}
+
+func TestCursorContext(t *testing.T) {
+	q := db.Query{Name: "user.Export", QueryRaw: "SELECT id FROM users"}
+
+	newPG := func(events *[]db.QueryEvent) *pg {
+		p := &pg{replicas: newReplicaSet()}
+		WithHooks(db.HookFuncs{
+			AfterFunc: func(ctx context.Context, e *db.QueryEvent) {
+				*events = append(*events, *e)
+			},
+		})(p)
+		return p
+	}
+
+	t.Run("fetches in batches", func(t *testing.T) {
+		var events []db.QueryEvent
+		p := newPG(&events)
+		tx := &cursorTx{values: []int64{1, 2, 3, 4, 5}}
+
+		rows, err := p.CursorContext(MakeContextTx(context.Background(), tx), q, 2, 42)
+		require.NoError(t, err)
+		require.Regexp(t, `^DECLARE db_cursor_\d+ NO SCROLL CURSOR FOR SELECT id FROM users$`, tx.statements[0])
+
+		require.Equal(t, []int64{1, 2, 3, 4, 5}, readCursor(t, rows))
+		require.NoError(t, rows.Err())
+		require.Equal(t, "SELECT 5", string(rows.CommandTag()))
+
+		// 5 rows by 2 is two full batches and a short one, which ends the iteration
+		require.Equal(t, 3, tx.fetches())
+		require.True(t, strings.HasPrefix(tx.statements[len(tx.statements)-1], "CLOSE db_cursor_"))
+		// the transaction belongs to the caller
+		require.False(t, tx.rolledBack)
+
+		require.Len(t, events, 1)
+		require.Equal(t, db.OpCursor, events[0].Operation)
+		require.Equal(t, int64(5), events[0].CommandTag.RowsAffected())
+	})
+
+	t.Run("exhausted on a full batch", func(t *testing.T) {
+		var events []db.QueryEvent
+		tx := &cursorTx{values: []int64{1, 2, 3, 4}}
+
+		rows, err := newPG(&events).CursorContext(MakeContextTx(context.Background(), tx), q, 2)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 2, 3, 4}, readCursor(t, rows))
+
+		// the last full batch can't tell the cursor is exhausted, one more empty fetch does
+		require.Equal(t, 3, tx.fetches())
+	})
+
+	t.Run("default fetch size", func(t *testing.T) {
+		var events []db.QueryEvent
+		tx := &cursorTx{}
+
+		rows, err := newPG(&events).CursorContext(MakeContextTx(context.Background(), tx), q, 0)
+		require.NoError(t, err)
+		require.Empty(t, readCursor(t, rows))
+		require.Contains(t, tx.statements, fmt.Sprintf("FETCH FORWARD %d FROM %s", DefaultFetchSize, rows.(*cursorRows).name))
+	})
+
+	t.Run("early close", func(t *testing.T) {
+		var events []db.QueryEvent
+		tx := &cursorTx{values: []int64{1, 2, 3, 4, 5}}
+
+		rows, err := newPG(&events).CursorContext(MakeContextTx(context.Background(), tx), q, 2)
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		rows.Close()
+		rows.Close()
+
+		require.False(t, rows.Next())
+		require.NoError(t, rows.Err())
+		require.Equal(t, 1, tx.fetches())
+		require.True(t, strings.HasPrefix(tx.statements[len(tx.statements)-1], "CLOSE db_cursor_"))
+		require.Len(t, events, 1)
+	})
+
+	t.Run("fetch error", func(t *testing.T) {
+		var events []db.QueryEvent
+		fetchErr := errors.New("canceling statement due to user request")
+		tx := &cursorTx{fetchErr: fetchErr}
+
+		_, err := newPG(&events).CursorContext(MakeContextTx(context.Background(), tx), q, 2)
+		require.ErrorIs(t, err, fetchErr)
+
+		// the failed transaction can't run CLOSE, the caller rolls it back
+		for _, s := range tx.statements {
+			require.False(t, strings.HasPrefix(s, "CLOSE"), s)
+		}
+		require.Len(t, events, 1)
+		require.ErrorIs(t, events[0].Err, fetchErr)
+	})
+}
+
+func TestCursorRowsOwnTransaction(t *testing.T) {
+	newRows := func(tx *cursorTx) (*cursorRows, *error) {
+		var finished error
+		r := &cursorRows{
+			ctx:       context.Background(),
+			tx:        tx,
+			ownTx:     true,
+			name:      "db_cursor_test",
+			fetchSize: 2,
+			finish: func(total int64, err error) error {
+				finished = err
+				return err
+			},
+		}
+		require.NoError(t, r.fetch())
+		return r, &finished
+	}
+
+	t.Run("rolled back when read to the end", func(t *testing.T) {
+		tx := &cursorTx{values: []int64{1, 2, 3}}
+		r, _ := newRows(tx)
+
+		require.Equal(t, []int64{1, 2, 3}, readCursor(t, r))
+		require.True(t, tx.rolledBack)
+		// rolling back closes the cursor
+		require.NotContains(t, tx.statements, "CLOSE db_cursor_test")
+	})
+
+	t.Run("rolled back on early close", func(t *testing.T) {
+		tx := &cursorTx{values: []int64{1, 2, 3}}
+		r, _ := newRows(tx)
+
+		require.True(t, r.Next())
+		r.Close()
+		require.True(t, tx.rolledBack)
+	})
+
+	t.Run("rolled back on fetch error", func(t *testing.T) {
+		tx := &cursorTx{values: []int64{1, 2, 3}}
+		r, finished := newRows(tx)
+
+		fetchErr := errors.New("connection reset")
+		tx.fetchErr = fetchErr
+
+		require.Equal(t, []int64{1, 2}, readCursor(t, r))
+		require.ErrorIs(t, r.Err(), fetchErr)
+		require.ErrorIs(t, *finished, fetchErr)
+		require.True(t, tx.rolledBack)
+	})
+}
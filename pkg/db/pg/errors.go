@@ -0,0 +1,180 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/puddle"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// SQLSTATE codes that are mapped individually, the rest are mapped by class
+const (
+	sqlStateNotNullViolation      = "23502"
+	sqlStateForeignKeyViolation   = "23503"
+	sqlStateUniqueViolation       = "23505"
+	sqlStateCheckViolation        = "23514"
+	sqlStateExclusionViolation    = "23P01"
+	sqlStateSerializationFailure  = "40001"
+	sqlStateDeadlockDetected      = "40P01"
+	sqlStateInsufficientPrivilege = "42501"
+	sqlStateQueryCanceled         = "57014"
+	sqlStateAdminShutdown         = "57P01"
+	sqlStateCrashShutdown         = "57P02"
+	sqlStateCannotConnectNow      = "57P03"
+)
+
+// defaultMessages are returned to clients instead of the raw Postgres error text
+var defaultMessages = map[codes.Code]string{
+	codes.Canceled:           "request canceled",
+	codes.InvalidArgument:    "invalid argument",
+	codes.DeadlineExceeded:   "request timed out",
+	codes.NotFound:           "not found",
+	codes.AlreadyExists:      "already exists",
+	codes.PermissionDenied:   "permission denied",
+	codes.ResourceExhausted:  "database resources exhausted",
+	codes.FailedPrecondition: "referenced entity is missing or still in use",
+	codes.Aborted:            "concurrent modification, please retry",
+	codes.Internal:           "internal database error",
+	codes.Unavailable:        "database unavailable",
+}
+
+// ErrorTranslator turns Postgres and connection errors into sys errors with codes.
+// Violations of constraints listed in the messages table get the friendly message from it.
+type ErrorTranslator struct {
+	constraintMessages map[string]string
+}
+
+// NewErrorTranslator creates a translator, constraintMessages maps constraint names to client messages
+func NewErrorTranslator(constraintMessages map[string]string) *ErrorTranslator {
+	return &ErrorTranslator{constraintMessages: constraintMessages}
+}
+
+// TranslateError translates err without constraint messages
+func TranslateError(err error) error {
+	return (&ErrorTranslator{}).Translate(err)
+}
+
+// Translate returns a sys error for database errors, other errors and sys errors are returned as is.
+// The database error stays in the chain, so errors.As and errors.Is still find the *pgconn.PgError.
+func (t *ErrorTranslator) Translate(err error) error {
+	if err == nil || sys.IsError(err) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		code := codeFromPgError(pgErr)
+		return &translatedError{sys: sys.NewError(t.message(pgErr.ConstraintName, code), code), cause: err}
+	}
+
+	code, ok := codeFromError(err)
+	if !ok {
+		return err
+	}
+
+	return &translatedError{sys: sys.NewError(defaultMessages[code], code), cause: err}
+}
+
+// translatedError is a sys error that keeps the database error it was translated from.
+// Error returns the client message only, the cause is not exposed to clients.
+type translatedError struct {
+	sys   error
+	cause error
+}
+
+func (e *translatedError) Error() string {
+	return e.sys.Error()
+}
+
+func (e *translatedError) Unwrap() []error {
+	return []error{e.sys, e.cause}
+}
+
+// NewErrorHook returns a hook that translates query errors with t
+func NewErrorHook(t *ErrorTranslator) db.Hook {
+	return db.HookFuncs{
+		AfterFunc: func(ctx context.Context, e *db.QueryEvent) {
+			e.Err = t.Translate(e.Err)
+		},
+	}
+}
+
+func (t *ErrorTranslator) message(constraint string, code codes.Code) string {
+	if msg, ok := t.constraintMessages[constraint]; ok && constraint != "" {
+		return msg
+	}
+
+	return defaultMessages[code]
+}
+
+func codeFromPgError(pgErr *pgconn.PgError) codes.Code {
+	switch pgErr.Code {
+	case sqlStateUniqueViolation, sqlStateExclusionViolation:
+		return codes.AlreadyExists
+	case sqlStateForeignKeyViolation:
+		return codes.FailedPrecondition
+	case sqlStateCheckViolation, sqlStateNotNullViolation:
+		return codes.InvalidArgument
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return codes.Aborted
+	case sqlStateInsufficientPrivilege:
+		return codes.PermissionDenied
+	case sqlStateQueryCanceled:
+		if strings.Contains(pgErr.Message, "statement timeout") {
+			return codes.DeadlineExceeded
+		}
+		return codes.Canceled
+	case sqlStateAdminShutdown, sqlStateCrashShutdown, sqlStateCannotConnectNow:
+		return codes.Unavailable
+	}
+
+	if len(pgErr.Code) < 2 {
+		return codes.Internal
+	}
+
+	switch pgErr.Code[:2] {
+	case "22", "23":
+		// data exception, integrity constraint violation
+		return codes.InvalidArgument
+	case "08":
+		// connection exception
+		return codes.Unavailable
+	case "53":
+		// insufficient resources
+		return codes.ResourceExhausted
+	case "40":
+		// transaction rollback
+		return codes.Aborted
+	}
+
+	return codes.Internal
+}
+
+func codeFromError(err error) (codes.Code, bool) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return codes.NotFound, true
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err):
+		return codes.DeadlineExceeded, true
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled, true
+	}
+
+	// failed connects wrap the dial error, closed connections fail before sending and are safe to retry
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, puddle.ErrClosedPool), pgconn.SafeToRetry(err):
+		return codes.Unavailable, true
+	}
+
+	return 0, false
+}
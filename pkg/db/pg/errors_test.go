@@ -0,0 +1,172 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/puddle"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+func TestErrorTranslator(t *testing.T) {
+	translator := NewErrorTranslator(map[string]string{
+		"users_email_key": "user with this email already exists",
+	})
+
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+		msg  string
+	}{
+		{
+			name: "unique violation with known constraint",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", Message: "duplicate key"},
+			code: codes.AlreadyExists,
+			msg:  "user with this email already exists",
+		},
+		{
+			name: "unique violation with unknown constraint",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "users_pkey"},
+			code: codes.AlreadyExists,
+			msg:  "already exists",
+		},
+		{
+			name: "foreign key violation",
+			err:  fmt.Errorf("insert order: %w", &pgconn.PgError{Code: "23503"}),
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "check violation",
+			err:  &pgconn.PgError{Code: "23514"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "data exception class",
+			err:  &pgconn.PgError{Code: "22P02"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "statement timeout",
+			err:  &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+			code: codes.DeadlineExceeded,
+		},
+		{
+			name: "user cancel",
+			err:  &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"},
+			code: codes.Canceled,
+		},
+		{
+			name: "serialization failure",
+			err:  &pgconn.PgError{Code: "40001"},
+			code: codes.Aborted,
+		},
+		{
+			name: "connection exception class",
+			err:  &pgconn.PgError{Code: "08006"},
+			code: codes.Unavailable,
+		},
+		{
+			name: "syntax error",
+			err:  &pgconn.PgError{Code: "42601"},
+			code: codes.Internal,
+		},
+		{
+			name: "no rows",
+			err:  pgx.ErrNoRows,
+			code: codes.NotFound,
+		},
+		{
+			name: "context deadline",
+			err:  context.DeadlineExceeded,
+			code: codes.DeadlineExceeded,
+		},
+		{
+			name: "context canceled",
+			err:  context.Canceled,
+			code: codes.Canceled,
+		},
+		{
+			name: "network error",
+			err:  fmt.Errorf("query: %w", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}),
+			code: codes.Unavailable,
+		},
+		{
+			name: "closed connection",
+			err:  fmt.Errorf("write: %w", net.ErrClosed),
+			code: codes.Unavailable,
+		},
+		{
+			name: "unexpected EOF",
+			err:  io.ErrUnexpectedEOF,
+			code: codes.Unavailable,
+		},
+		{
+			name: "closed pool",
+			err:  puddle.ErrClosedPool,
+			code: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translator.Translate(tt.err)
+
+			sysErr := sys.GetError(err)
+			require.NotNil(t, sysErr)
+			require.Equal(t, tt.code, sysErr.Code())
+			if tt.msg != "" {
+				require.Equal(t, tt.msg, sysErr.Error())
+			}
+		})
+	}
+
+	t.Run("cause is kept", func(t *testing.T) {
+		pgErr := &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", Message: "duplicate key"}
+		err := translator.Translate(fmt.Errorf("create user: %w", pgErr))
+
+		var got *pgconn.PgError
+		require.ErrorAs(t, err, &got)
+		require.Same(t, pgErr, got)
+		// the client only sees the translated message
+		require.Equal(t, "user with this email already exists", err.Error())
+		// translated errors are not translated again
+		require.Same(t, err, translator.Translate(err))
+
+		err = translator.Translate(pgx.ErrNoRows)
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+		require.Equal(t, codes.NotFound, sys.GetError(err).Code())
+	})
+
+	t.Run("failed connect", func(t *testing.T) {
+		config, err := pgconn.ParseConfig("postgres://127.0.0.1:1/test?connect_timeout=1")
+		require.NoError(t, err)
+		_, err = pgconn.ConnectConfig(context.Background(), config)
+		require.Error(t, err)
+
+		require.Equal(t, codes.Unavailable, sys.GetError(translator.Translate(err)).Code(), err.Error())
+	})
+
+	t.Run("other errors are kept", func(t *testing.T) {
+		err := errors.New("scany: scan failed")
+		require.Equal(t, err, translator.Translate(err))
+		require.NoError(t, translator.Translate(nil))
+	})
+
+	t.Run("hook replaces the query error", func(t *testing.T) {
+		hook := NewErrorHook(translator)
+		e := &db.QueryEvent{Err: &pgconn.PgError{Code: "23503"}}
+		hook.After(context.Background(), e)
+
+		require.Equal(t, codes.FailedPrecondition, sys.GetError(e.Err).Code())
+	})
+}
@@ -0,0 +1,124 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/prettier"
+)
+
+// LoggerDefault logs every finished query with its duration, affected rows and error
+var LoggerDefault db.Hook = db.HookFuncs{
+	AfterFunc: func(ctx context.Context, e *db.QueryEvent) {
+		prettyQuery := prettier.Pretty(e.Query.QueryRaw, prettier.PlaceholderDollar, e.Args...)
+		log.Println(
+			ctx,
+			fmt.Sprintf("sql: %s", e.Query.Name),
+			fmt.Sprintf("query: %s", prettyQuery),
+			fmt.Sprintf("duration: %s", e.Duration),
+			fmt.Sprintf("rows: %d", e.RowsAffected),
+			fmt.Sprintf("tx: %t", e.InTx),
+			fmt.Sprintf("error: %v", e.Err),
+		)
+	},
+}
+
+// WithHooks adds hooks that are called around every DB operation
+func WithHooks(hooks ...db.Hook) Option {
+	return func(p *pg) {
+		for _, h := range hooks {
+			if h != nil {
+				p.hooks = append(p.hooks, h)
+			}
+		}
+	}
+}
+
+// LogFuncHook adapts a LogFunc to a hook that is called before every DB operation
+func LogFuncHook(logFunc LogFunc) db.Hook {
+	return db.HookFuncs{
+		BeforeFunc: func(ctx context.Context, e *db.QueryEvent) context.Context {
+			logFunc(ctx, e.Query, e.Args...)
+			return ctx
+		},
+	}
+}
+
+// before starts a new event and runs Before hooks
+func (p *pg) before(ctx context.Context, op string, q db.Query, args []interface{}) (context.Context, *db.QueryEvent) {
+	_, inTx := ctx.Value(TxKey).(pgx.Tx)
+	e := &db.QueryEvent{
+		Operation: op,
+		Query:     q,
+		Args:      args,
+		InTx:      inTx,
+		StartTime: time.Now(),
+	}
+
+	return p.hooks.Before(ctx, e), e
+}
+
+// after completes the event, runs After hooks and returns the possibly replaced error
+func (p *pg) after(ctx context.Context, e *db.QueryEvent, err error) error {
+	e.Duration = time.Since(e.StartTime)
+	e.Err = err
+	if err == nil && e.RowsAffected == 0 {
+		e.RowsAffected = e.CommandTag.RowsAffected()
+	}
+
+	p.hooks.After(ctx, e)
+	return e.Err
+}
+
+// hookRows runs After hooks once the rows are exhausted or closed
+type hookRows struct {
+	pgx.Rows
+	finish   func(err error) error
+	finished bool
+	err      error
+}
+
+func (r *hookRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.done()
+	return false
+}
+
+func (r *hookRows) Close() {
+	r.Rows.Close()
+	r.done()
+}
+
+func (r *hookRows) Err() error {
+	if r.finished {
+		return r.err
+	}
+
+	return r.Rows.Err()
+}
+
+func (r *hookRows) done() {
+	if r.finished {
+		return
+	}
+
+	r.finished = true
+	r.err = r.finish(r.Rows.Err())
+}
+
+// hookRow runs After hooks when the row is scanned
+type hookRow struct {
+	pgx.Row
+	finish func(err error) error
+}
+
+func (r *hookRow) Scan(dest ...interface{}) error {
+	return r.finish(r.Row.Scan(dest...))
+}
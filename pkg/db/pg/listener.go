@@ -0,0 +1,222 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	closeConnTimeout  = 5 * time.Second
+)
+
+// Notification is a NOTIFY message received by a Subscriber
+type Notification struct {
+	Channel string
+	Payload string
+	PID     uint32
+	// Missed is set after a reconnect, notifications on Channel may have been lost while disconnected
+	Missed bool
+}
+
+// NotificationHandler receives notifications when set with WithNotificationHandler
+type NotificationHandler func(ctx context.Context, n Notification)
+
+// listenConn is the connection a Subscriber listens on
+type listenConn interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	// Close closes the connection, it is still listening so it must not go back to the pool
+	Close(ctx context.Context) error
+}
+
+// poolConn is a connection acquired from a pool, returned by Acquire and listened on by a Subscriber
+type poolConn struct {
+	*pgxpool.Conn
+}
+
+func (c poolConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	return c.Conn.Conn().WaitForNotification(ctx)
+}
+
+func (c poolConn) Close(ctx context.Context) error {
+	err := c.Conn.Conn().Close(ctx)
+	c.Conn.Release()
+	return err
+}
+
+// Subscriber LISTENs on channels using a dedicated connection from the pool and reconnects with backoff
+type Subscriber struct {
+	acquire    func(ctx context.Context) (listenConn, error)
+	channels   []string
+	handler    NotificationHandler
+	errFunc    func(err error)
+	out        chan Notification
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// SubscriberOption configures the subscriber
+type SubscriberOption func(*Subscriber)
+
+// WithNotificationHandler delivers notifications to h instead of the Notifications channel
+func WithNotificationHandler(h NotificationHandler) SubscriberOption {
+	return func(s *Subscriber) {
+		s.handler = h
+	}
+}
+
+// WithNotificationBuffer sets the size of the Notifications channel buffer
+func WithNotificationBuffer(size int) SubscriberOption {
+	return func(s *Subscriber) {
+		s.out = make(chan Notification, size)
+	}
+}
+
+// WithReconnectBackoff sets the minimum and maximum delay between reconnect attempts
+func WithReconnectBackoff(min, max time.Duration) SubscriberOption {
+	return func(s *Subscriber) {
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
+
+// WithSubscriberErrorFunc sets the function that receives connection errors before reconnecting
+func WithSubscriberErrorFunc(f func(err error)) SubscriberOption {
+	return func(s *Subscriber) {
+		s.errFunc = f
+	}
+}
+
+// NewSubscriber creates a subscriber for channels, call Run to start listening
+func NewSubscriber(pool *pgxpool.Pool, channels []string, opts ...SubscriberOption) *Subscriber {
+	s := &Subscriber{
+		acquire: func(ctx context.Context) (listenConn, error) {
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return poolConn{conn}, nil
+		},
+		channels:   channels,
+		errFunc:    func(err error) {}, // Use a no-op error function by default
+		out:        make(chan Notification, 64),
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Notifications returns the channel notifications are delivered to, it is closed when Run returns
+func (s *Subscriber) Notifications() <-chan Notification {
+	return s.out
+}
+
+// Run listens until ctx is done. After a connection loss it reconnects with backoff, LISTENs again
+// and delivers a Notification with Missed set for every channel.
+func (s *Subscriber) Run(ctx context.Context) error {
+	defer close(s.out)
+
+	backoff := s.minBackoff
+	connected := false
+	for {
+		err := s.listen(ctx, connected, func() {
+			connected = true
+			backoff = s.minBackoff
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		s.errFunc(err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = nextBackoff(backoff, s.maxBackoff)
+	}
+}
+
+// nextBackoff doubles the reconnect delay up to max
+func nextBackoff(cur, max time.Duration) time.Duration {
+	if cur >= max/2 {
+		return max
+	}
+
+	return cur * 2
+}
+
+// listen holds one connection until it fails or ctx is done
+func (s *Subscriber) listen(ctx context.Context, reconnect bool, onConnected func()) error {
+	conn, err := s.acquire(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't acquire connection")
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), closeConnTimeout)
+		defer cancel()
+
+		_ = conn.Close(closeCtx)
+	}()
+
+	for _, channel := range s.channels {
+		if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return errors.Wrapf(err, "can't listen on %s", channel)
+		}
+	}
+
+	onConnected()
+	if reconnect {
+		for _, channel := range s.channels {
+			s.deliver(ctx, Notification{Channel: channel, Missed: true})
+		}
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return errors.Wrap(err, "can't wait for notification")
+		}
+
+		s.deliver(ctx, Notification{Channel: n.Channel, Payload: n.Payload, PID: n.PID})
+	}
+}
+
+func (s *Subscriber) deliver(ctx context.Context, n Notification) {
+	if s.handler != nil {
+		s.handler(ctx, n)
+		return
+	}
+
+	select {
+	case s.out <- n:
+	case <-ctx.Done():
+	}
+}
+
+// Notify sends a notification with pg_notify, inside the transaction from ctx if present.
+// Notifications sent in a transaction are delivered when it commits.
+func Notify(ctx context.Context, d db.QueryExecer, channel, payload string) error {
+	_, err := d.ExecContext(ctx, db.Query{
+		Name:     "pg.notify",
+		QueryRaw: "SELECT pg_notify($1, $2)",
+	}, channel, payload)
+
+	return errors.Wrapf(err, "can't notify %s", channel)
+}
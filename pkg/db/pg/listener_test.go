@@ -0,0 +1,139 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeListenConn returns its notifications and then err, or blocks until ctx is done when err is nil
+type fakeListenConn struct {
+	listened      []string
+	notifications []*pgconn.Notification
+	err           error
+	closed        bool
+}
+
+func (c *fakeListenConn) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	c.listened = append(c.listened, sql)
+	return pgconn.CommandTag("LISTEN"), nil
+}
+
+func (c *fakeListenConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	if len(c.notifications) > 0 {
+		n := c.notifications[0]
+		c.notifications = c.notifications[1:]
+		return n, nil
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *fakeListenConn) Close(ctx context.Context) error {
+	c.closed = true
+	return nil
+}
+
+func TestNextBackoff(t *testing.T) {
+	require.Equal(t, time.Second, nextBackoff(500*time.Millisecond, 30*time.Second))
+	require.Equal(t, 16*time.Second, nextBackoff(8*time.Second, 30*time.Second))
+	require.Equal(t, 30*time.Second, nextBackoff(20*time.Second, 30*time.Second))
+	require.Equal(t, 30*time.Second, nextBackoff(30*time.Second, 30*time.Second))
+}
+
+func TestSubscriber(t *testing.T) {
+	t.Run("reconnect", func(t *testing.T) {
+		conns := []*fakeListenConn{
+			{
+				notifications: []*pgconn.Notification{{Channel: "orders", Payload: "1", PID: 7}},
+				err:           errors.New("connection lost"),
+			},
+			{
+				notifications: []*pgconn.Notification{{Channel: "users", Payload: "2", PID: 8}},
+			},
+		}
+		attempts := 0
+
+		var errs []error
+		s := NewSubscriber(nil, []string{"orders", "users"},
+			WithReconnectBackoff(time.Millisecond, 4*time.Millisecond),
+			WithSubscriberErrorFunc(func(err error) {
+				errs = append(errs, err)
+			}),
+		)
+		s.acquire = func(ctx context.Context) (listenConn, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("connection refused")
+			}
+
+			return conns[attempts-2], nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+
+		var got []Notification
+		for n := range s.Notifications() {
+			got = append(got, n)
+			if len(got) == 4 {
+				cancel()
+			}
+		}
+		require.NoError(t, <-done)
+
+		// the first connection is not a reconnect, even after a failed attempt
+		require.Equal(t, []Notification{
+			{Channel: "orders", Payload: "1", PID: 7},
+			{Channel: "orders", Missed: true},
+			{Channel: "users", Missed: true},
+			{Channel: "users", Payload: "2", PID: 8},
+		}, got)
+
+		require.Len(t, errs, 2)
+		require.Contains(t, errs[0].Error(), "connection refused")
+		require.Contains(t, errs[1].Error(), "connection lost")
+
+		for _, c := range conns {
+			require.Equal(t, []string{`LISTEN "orders"`, `LISTEN "users"`}, c.listened)
+			require.True(t, c.closed)
+		}
+	})
+
+	t.Run("handler delivery", func(t *testing.T) {
+		var got []Notification
+		s := NewSubscriber(nil, []string{"orders"}, WithNotificationHandler(func(ctx context.Context, n Notification) {
+			got = append(got, n)
+		}))
+
+		s.deliver(context.Background(), Notification{Channel: "orders", Payload: "1"})
+		require.Equal(t, []Notification{{Channel: "orders", Payload: "1"}}, got)
+		require.Empty(t, s.Notifications())
+	})
+
+	t.Run("channel delivery stops with the context", func(t *testing.T) {
+		s := NewSubscriber(nil, []string{"orders"}, WithNotificationBuffer(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s.deliver(ctx, Notification{Channel: "orders", Payload: "1"})
+		cancel()
+		// the buffer is full, the notification is dropped instead of blocking
+		s.deliver(ctx, Notification{Channel: "orders", Payload: "2"})
+
+		require.Equal(t, Notification{Channel: "orders", Payload: "1"}, <-s.Notifications())
+		require.Empty(t, s.Notifications())
+	})
+}
@@ -0,0 +1,229 @@
+package pg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// DefaultBuckets are latency histogram buckets in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsRecorder receives per-query observations from the metrics hook
+type MetricsRecorder interface {
+	ObserveQuery(name string, d time.Duration, err error)
+}
+
+type metricsHook struct {
+	rec MetricsRecorder
+}
+
+// NewMetricsHook returns a hook that reports the latency and error of every query to rec
+func NewMetricsHook(rec MetricsRecorder) db.Hook {
+	return &metricsHook{rec: rec}
+}
+
+func (h *metricsHook) Before(ctx context.Context, e *db.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *metricsHook) After(ctx context.Context, e *db.QueryEvent) {
+	name := e.Query.Name
+	if name == "" {
+		name = "unnamed"
+	}
+
+	h.rec.ObserveQuery(name, e.Duration, e.Err)
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Collector is an in-memory MetricsRecorder that also reports pool stats.
+// It serves the collected metrics in the Prometheus text exposition format.
+type Collector struct {
+	mu        sync.Mutex
+	namespace string
+	buckets   []float64
+	latency   map[string]*histogram
+	errors    map[string]uint64
+	pools     map[string]*pgxpool.Pool
+}
+
+// CollectorOption configures the collector
+type CollectorOption func(*Collector)
+
+// WithNamespace sets the metric name prefix, "db" by default
+func WithNamespace(namespace string) CollectorOption {
+	return func(c *Collector) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the latency histogram buckets in seconds
+func WithBuckets(buckets []float64) CollectorOption {
+	return func(c *Collector) {
+		c.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(c.buckets)
+	}
+}
+
+// WithPool adds a pool whose stats are reported with the pool label set to name
+func WithPool(name string, pool *pgxpool.Pool) CollectorOption {
+	return func(c *Collector) {
+		c.pools[name] = pool
+	}
+}
+
+// NewCollector creates a new metrics collector
+func NewCollector(opts ...CollectorOption) *Collector {
+	c := &Collector{
+		namespace: "db",
+		buckets:   DefaultBuckets,
+		latency:   make(map[string]*histogram),
+		errors:    make(map[string]uint64),
+		pools:     make(map[string]*pgxpool.Pool),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ObserveQuery records the latency and error of a query
+func (c *Collector) ObserveQuery(name string, d time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.latency[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.latency[name] = h
+	}
+
+	seconds := d.Seconds()
+	for i, upper := range c.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if err != nil {
+		c.errors[name]++
+	}
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	c.mu.Lock()
+	c.writeQueries(bw)
+	c.mu.Unlock()
+
+	c.writePools(bw)
+
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler for the metrics endpoint
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := c.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (c *Collector) writeQueries(w io.Writer) {
+	names := make([]string, 0, len(c.latency))
+	for name := range c.latency {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metric := c.namespace + "_query_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Query latency by query name.\n", metric)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", metric)
+	for _, name := range names {
+		h := c.latency[name]
+		label := escapeLabel(name)
+		for i, upper := range c.buckets {
+			fmt.Fprintf(w, "%s_bucket{query=\"%s\",le=\"%g\"} %d\n", metric, label, upper, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{query=\"%s\",le=\"+Inf\"} %d\n", metric, label, h.count)
+		fmt.Fprintf(w, "%s_sum{query=\"%s\"} %g\n", metric, label, h.sum)
+		fmt.Fprintf(w, "%s_count{query=\"%s\"} %d\n", metric, label, h.count)
+	}
+
+	metric = c.namespace + "_query_errors_total"
+	fmt.Fprintf(w, "# HELP %s Failed queries by query name.\n", metric)
+	fmt.Fprintf(w, "# TYPE %s counter\n", metric)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s{query=\"%s\"} %d\n", metric, escapeLabel(name), c.errors[name])
+	}
+}
+
+func (c *Collector) writePools(w io.Writer) {
+	if len(c.pools) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(c.pools))
+	stats := make(map[string]*pgxpool.Stat, len(c.pools))
+	for name, pool := range c.pools {
+		names = append(names, name)
+		stats[name] = pool.Stat()
+	}
+	sort.Strings(names)
+
+	gauges := []struct {
+		name, help, typ string
+		value           func(s *pgxpool.Stat) float64
+	}{
+		{"pool_acquired_conns", "Connections currently acquired from the pool.", "gauge",
+			func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }},
+		{"pool_idle_conns", "Idle connections in the pool.", "gauge",
+			func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }},
+		{"pool_total_conns", "Total connections in the pool.", "gauge",
+			func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }},
+		{"pool_max_conns", "Maximum size of the pool.", "gauge",
+			func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }},
+		{"pool_waited_acquires_total", "Acquires that had to wait for a connection.", "counter",
+			func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }},
+		{"pool_acquires_total", "Successful acquires from the pool.", "counter",
+			func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }},
+		{"pool_acquire_duration_seconds_total", "Total time spent acquiring connections.", "counter",
+			func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }},
+	}
+
+	for _, g := range gauges {
+		metric := c.namespace + "_" + g.name
+		fmt.Fprintf(w, "# HELP %s %s\n", metric, g.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric, g.typ)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{pool=\"%s\"} %g\n", metric, escapeLabel(name), g.value(stats[name]))
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
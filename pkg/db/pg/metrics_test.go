@@ -0,0 +1,49 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector(WithBuckets([]float64{0.1, 0.01}))
+	hook := NewMetricsHook(c)
+
+	observe := func(name string, d time.Duration, err error) {
+		e := &db.QueryEvent{Query: db.Query{Name: name}}
+		ctx := hook.Before(context.Background(), e)
+		e.Duration, e.Err = d, err
+		hook.After(ctx, e)
+	}
+
+	observe("user.Get", 5*time.Millisecond, nil)
+	observe("user.Get", 50*time.Millisecond, errors.New("failed"))
+	observe(`weird"name`, time.Second, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.WritePrometheus(&buf))
+
+	require.Equal(t, `# HELP db_query_duration_seconds Query latency by query name.
+# TYPE db_query_duration_seconds histogram
+db_query_duration_seconds_bucket{query="user.Get",le="0.01"} 1
+db_query_duration_seconds_bucket{query="user.Get",le="0.1"} 2
+db_query_duration_seconds_bucket{query="user.Get",le="+Inf"} 2
+db_query_duration_seconds_sum{query="user.Get"} 0.055
+db_query_duration_seconds_count{query="user.Get"} 2
+db_query_duration_seconds_bucket{query="weird\"name",le="0.01"} 0
+db_query_duration_seconds_bucket{query="weird\"name",le="0.1"} 0
+db_query_duration_seconds_bucket{query="weird\"name",le="+Inf"} 1
+db_query_duration_seconds_sum{query="weird\"name"} 1
+db_query_duration_seconds_count{query="weird\"name"} 1
+# HELP db_query_errors_total Failed queries by query name.
+# TYPE db_query_errors_total counter
+db_query_errors_total{query="user.Get"} 1
+db_query_errors_total{query="weird\"name"} 0
+`, buf.String())
+}
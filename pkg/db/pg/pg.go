@@ -2,14 +2,13 @@ package pg
 
 import (
 	"context"
-	"fmt"
+	"time"
+
 	"github.com/georgysavva/scany/pgxscan"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/t34-dev/go-utils/pkg/db"
-	"github.com/t34-dev/go-utils/pkg/db/prettier"
-	"log"
 )
 
 type key string
@@ -18,80 +17,215 @@ const (
 	TxKey key = "tx"
 )
 
+// Option configures the database wrapper
+type Option func(*pg)
+
+// querier is implemented by both pgx.Tx and *pgxpool.Pool
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
 type pg struct {
-	dbc     *pgxpool.Pool
-	LogFunc LogFunc
+	dbc      *pgxpool.Pool
+	replicas *replicaSet
+	hooks    db.Hooks
+
+	defaultTimeout time.Duration
+	tenancy        *tenancy
 }
 
-func NewDB(dbc *pgxpool.Pool, logFunc *LogFunc) db.DB {
+// NewDB wraps the pool into db.DB.
+// A non-nil logFunc is called before every operation, hooks added with WithHooks run after it.
+func NewDB(dbc *pgxpool.Pool, logFunc *LogFunc, opts ...Option) db.DB {
 	c := &pg{
-		LogFunc: LogFunc(func(ctx context.Context, q db.Query, args ...interface{}) {
-			if logFunc != nil {
-				(*logFunc)(ctx, q, args...)
-			}
-		}),
-		dbc: dbc,
+		dbc:      dbc,
+		replicas: newReplicaSet(),
+	}
+
+	if logFunc != nil {
+		c.hooks = append(c.hooks, LogFuncHook(*logFunc))
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	c.replicas.start()
 	return c
 }
 
+// conn returns the transaction from ctx, a healthy replica for read-only queries or the primary pool
+func (p *pg) conn(ctx context.Context, q db.Query) querier {
+	if tx, ok := ctx.Value(TxKey).(pgx.Tx); ok {
+		return tx
+	}
+
+	return p.pool(ctx, q)
+}
+
+// pool returns a healthy replica for read-only queries or the primary pool
+func (p *pg) pool(ctx context.Context, q db.Query) *pgxpool.Pool {
+	if q.ReadOnly || IsReadOnly(ctx) {
+		if replica := p.replicas.pick(); replica != nil {
+			return replica
+		}
+	}
+
+	return p.dbc
+}
+
 func (p *pg) ScanOneContext(ctx context.Context, dest interface{}, q db.Query, args ...interface{}) error {
-	p.LogFunc(ctx, q, args...)
+	if p.tenancy.wraps(ctx) {
+		return p.inTenantTx(ctx, p.pool(ctx, q), p.failed(ctx, db.OpScanOne, q, args), func(ctx context.Context) error {
+			return p.ScanOneContext(ctx, dest, q, args...)
+		})
+	}
+
+	ctx, e := p.before(ctx, db.OpScanOne, q, args)
 
-	row, err := p.QueryContext(ctx, q, args...)
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	defer release()
 	if err != nil {
-		return err
+		return p.after(ctx, e, err)
 	}
 
-	return pgxscan.ScanOne(dest, row)
+	rows, err := p.conn(ctx, q).Query(qctx, q.QueryRaw, args...)
+	if err != nil {
+		return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
+	}
+
+	err = pgxscan.ScanOne(dest, rows)
+	e.CommandTag = rows.CommandTag()
+	return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
 }
 
 func (p *pg) ScanAllContext(ctx context.Context, dest interface{}, q db.Query, args ...interface{}) error {
-	p.LogFunc(ctx, q, args...)
+	if p.tenancy.wraps(ctx) {
+		return p.inTenantTx(ctx, p.pool(ctx, q), p.failed(ctx, db.OpScanAll, q, args), func(ctx context.Context) error {
+			return p.ScanAllContext(ctx, dest, q, args...)
+		})
+	}
+
+	ctx, e := p.before(ctx, db.OpScanAll, q, args)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	defer release()
+	if err != nil {
+		return p.after(ctx, e, err)
+	}
 
-	rows, err := p.QueryContext(ctx, q, args...)
+	rows, err := p.conn(ctx, q).Query(qctx, q.QueryRaw, args...)
 	if err != nil {
-		return err
+		return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
 	}
 
-	return pgxscan.ScanAll(dest, rows)
+	err = pgxscan.ScanAll(dest, rows)
+	e.CommandTag = rows.CommandTag()
+	return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
 }
 
 func (p *pg) ExecContext(ctx context.Context, q db.Query, args ...interface{}) (pgconn.CommandTag, error) {
-	p.LogFunc(ctx, q, args...)
+	if p.tenancy.wraps(ctx) {
+		var tag pgconn.CommandTag
+		err := p.inTenantTx(ctx, p.dbc, p.failed(ctx, db.OpExec, q, args), func(ctx context.Context) (err error) {
+			tag, err = p.ExecContext(ctx, q, args...)
+			return err
+		})
+		return tag, err
+	}
 
+	ctx, e := p.before(ctx, db.OpExec, q, args)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	defer release()
+	if err != nil {
+		return nil, p.after(ctx, e, err)
+	}
+
+	// writes always go to the primary
+	var tag pgconn.CommandTag
 	tx, ok := ctx.Value(TxKey).(pgx.Tx)
 	if ok {
-		return tx.Exec(ctx, q.QueryRaw, args...)
+		tag, err = tx.Exec(qctx, q.QueryRaw, args...)
+	} else {
+		tag, err = p.dbc.Exec(qctx, q.QueryRaw, args...)
 	}
 
-	return p.dbc.Exec(ctx, q.QueryRaw, args...)
+	e.CommandTag = tag
+	return tag, p.after(ctx, e, timeoutError(ctx, q, timeout, err))
 }
 
+// QueryContext runs the query, the timeout lasts until the rows are closed
 func (p *pg) QueryContext(ctx context.Context, q db.Query, args ...interface{}) (pgx.Rows, error) {
-	p.LogFunc(ctx, q, args...)
+	if p.tenancy.wraps(ctx) {
+		return p.tenantRows(ctx, p.pool(ctx, q), q, args)
+	}
 
-	tx, ok := ctx.Value(TxKey).(pgx.Tx)
-	if ok {
-		return tx.Query(ctx, q.QueryRaw, args...)
+	ctx, e := p.before(ctx, db.OpQuery, q, args)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	if err != nil {
+		release()
+		return nil, p.after(ctx, e, err)
+	}
+
+	rows, err := p.conn(ctx, q).Query(qctx, q.QueryRaw, args...)
+	if err != nil {
+		release()
+		return nil, p.after(ctx, e, timeoutError(ctx, q, timeout, err))
 	}
 
-	return p.dbc.Query(ctx, q.QueryRaw, args...)
+	return &hookRows{
+		Rows: rows,
+		finish: func(err error) error {
+			defer release()
+			e.CommandTag = rows.CommandTag()
+			return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
+		},
+	}, nil
 }
 
+// QueryRowContext runs the query, the timeout lasts until the row is scanned
 func (p *pg) QueryRowContext(ctx context.Context, q db.Query, args ...interface{}) pgx.Row {
-	p.LogFunc(ctx, q, args...)
+	if p.tenancy.wraps(ctx) {
+		return p.tenantRow(ctx, p.pool(ctx, q), q, args)
+	}
 
-	tx, ok := ctx.Value(TxKey).(pgx.Tx)
-	if ok {
-		return tx.QueryRow(ctx, q.QueryRaw, args...)
+	ctx, e := p.before(ctx, db.OpQueryRow, q, args)
+
+	qctx, release, timeout, err := p.deadline(ctx, q)
+	if err != nil {
+		release()
+		return errRow{err: p.after(ctx, e, err)}
 	}
 
-	return p.dbc.QueryRow(ctx, q.QueryRaw, args...)
+	row := p.conn(ctx, q).QueryRow(qctx, q.QueryRaw, args...)
+	return &hookRow{
+		Row: row,
+		finish: func(err error) error {
+			defer release()
+			if err == nil {
+				e.RowsAffected = 1
+			}
+			return p.after(ctx, e, timeoutError(ctx, q, timeout, err))
+		},
+	}
 }
 
+// BeginTx begins a transaction on the primary, with the tenant from ctx applied when tenancy is enabled
 func (p *pg) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
-	return p.dbc.BeginTx(ctx, txOptions)
+	return p.begin(ctx, p.dbc, txOptions)
+}
+
+func (p *pg) Acquire(ctx context.Context) (db.Conn, error) {
+	conn, err := p.dbc.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return poolConn{conn}, nil
 }
 
 func (p *pg) Ping(ctx context.Context) error {
@@ -99,18 +233,10 @@ func (p *pg) Ping(ctx context.Context) error {
 }
 
 func (p *pg) Close() {
+	p.replicas.close()
 	p.dbc.Close()
 }
 
 func MakeContextTx(ctx context.Context, tx pgx.Tx) context.Context {
 	return context.WithValue(ctx, TxKey, tx)
 }
-
-func LoggerDefault(ctx context.Context, q db.Query, args ...interface{}) {
-	prettyQuery := prettier.Pretty(q.QueryRaw, prettier.PlaceholderDollar, args...)
-	log.Println(
-		ctx,
-		fmt.Sprintf("sql: %s", q.Name),
-		fmt.Sprintf("query: %s", prettyQuery),
-	)
-}
@@ -0,0 +1,209 @@
+package pg
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type readOnlyKey struct{}
+
+// Balancer selects a replica for read-only queries
+type Balancer int
+
+const (
+	// RoundRobin cycles through healthy replicas
+	RoundRobin Balancer = iota
+	// LeastConnections picks the healthy replica with the fewest acquired connections
+	LeastConnections
+)
+
+const (
+	defaultMaxReplicationLag   = 10 * time.Second
+	defaultReplicaCheckPeriod  = 5 * time.Second
+	defaultReplicaCheckTimeout = 2 * time.Second
+)
+
+// replicationLagQuery returns the replica lag in seconds, or 0 when the replica streams from the primary
+// and has replayed everything it received. Without a streaming WAL receiver the received LSN stops moving,
+// so the lag is the age of the last replayed transaction.
+const replicationLagQuery = `SELECT CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+		AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+// WithReadOnly marks all queries executed with ctx as read-only, so they can be routed to a replica
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// IsReadOnly reports whether ctx was marked with WithReadOnly
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// WithReplicas adds replica pools used for read-only queries outside of transactions
+func WithReplicas(pools ...*pgxpool.Pool) Option {
+	return func(p *pg) {
+		for _, pool := range pools {
+			if pool != nil {
+				p.replicas.pools = append(p.replicas.pools, newReplica(pool))
+			}
+		}
+	}
+}
+
+// WithBalancer sets the replica selection strategy, RoundRobin by default
+func WithBalancer(b Balancer) Option {
+	return func(p *pg) {
+		p.replicas.balancer = b
+	}
+}
+
+// WithMaxReplicationLag sets the replication lag after which a replica is skipped
+func WithMaxReplicationLag(d time.Duration) Option {
+	return func(p *pg) {
+		p.replicas.maxLag = d
+	}
+}
+
+// WithReplicaCheckPeriod sets how often replicas are checked for health and replication lag
+func WithReplicaCheckPeriod(d time.Duration) Option {
+	return func(p *pg) {
+		p.replicas.checkPeriod = d
+	}
+}
+
+type replica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+
+	// lag and acquiredConns query the pool, replaced in tests
+	lag           func(ctx context.Context) (time.Duration, error)
+	acquiredConns func() int32
+}
+
+func newReplica(pool *pgxpool.Pool) *replica {
+	return &replica{
+		pool: pool,
+		lag: func(ctx context.Context) (time.Duration, error) {
+			var seconds float64
+			err := pool.QueryRow(ctx, replicationLagQuery).Scan(&seconds)
+			return time.Duration(seconds * float64(time.Second)), err
+		},
+		acquiredConns: func() int32 {
+			return pool.Stat().AcquiredConns()
+		},
+	}
+}
+
+type replicaSet struct {
+	pools       []*replica
+	balancer    Balancer
+	maxLag      time.Duration
+	checkPeriod time.Duration
+	next        atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newReplicaSet() *replicaSet {
+	return &replicaSet{
+		maxLag:      defaultMaxReplicationLag,
+		checkPeriod: defaultReplicaCheckPeriod,
+		stop:        make(chan struct{}),
+	}
+}
+
+// start checks the replicas once, so unreachable or lagging replicas are never picked,
+// and launches the background health check
+func (rs *replicaSet) start() {
+	if len(rs.pools) == 0 {
+		return
+	}
+
+	rs.check()
+
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		ticker := time.NewTicker(rs.checkPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-rs.stop:
+				return
+			case <-ticker.C:
+				rs.check()
+			}
+		}
+	}()
+}
+
+// check updates the health of all replicas concurrently, so it takes at most defaultReplicaCheckTimeout
+func (rs *replicaSet) check() {
+	var wg sync.WaitGroup
+	for _, r := range rs.pools {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), defaultReplicaCheckTimeout)
+			defer cancel()
+
+			lag, err := r.lag(ctx)
+			r.healthy.Store(err == nil && lag <= rs.maxLag)
+		}(r)
+	}
+
+	wg.Wait()
+}
+
+// pick returns a healthy replica pool, or nil if there is none
+func (rs *replicaSet) pick() *pgxpool.Pool {
+	healthy := make([]*replica, 0, len(rs.pools))
+	for _, r := range rs.pools {
+		if r.healthy.Load() {
+			healthy = append(healthy, r)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if rs.balancer == LeastConnections {
+		best := healthy[0]
+		for _, r := range healthy[1:] {
+			if r.acquiredConns() < best.acquiredConns() {
+				best = r
+			}
+		}
+
+		return best.pool
+	}
+
+	n := rs.next.Add(1) - 1
+	return healthy[n%uint64(len(healthy))].pool
+}
+
+// close stops the health check and closes the replica pools, it is safe to call several times
+func (rs *replicaSet) close() {
+	rs.stopOnce.Do(func() {
+		close(rs.stop)
+		rs.wg.Wait()
+
+		for _, r := range rs.pools {
+			r.pool.Close()
+		}
+	})
+}
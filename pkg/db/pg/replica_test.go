@@ -0,0 +1,133 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// fakeReplica returns a replica with a fixed replication lag and number of acquired connections
+func fakeReplica(lag time.Duration, lagErr error, conns int32) *replica {
+	return &replica{
+		pool: &pgxpool.Pool{},
+		lag: func(ctx context.Context) (time.Duration, error) {
+			return lag, lagErr
+		},
+		acquiredConns: func() int32 {
+			return conns
+		},
+	}
+}
+
+func TestReplicaSet(t *testing.T) {
+	t.Run("check skips unreachable and lagging replicas", func(t *testing.T) {
+		rs := newReplicaSet()
+		rs.maxLag = time.Second
+		ok := fakeReplica(time.Second, nil, 0)
+		lagging := fakeReplica(2*time.Second, nil, 0)
+		down := fakeReplica(0, errors.New("connection refused"), 0)
+		rs.pools = []*replica{lagging, ok, down}
+
+		rs.check()
+		require.False(t, lagging.healthy.Load())
+		require.True(t, ok.healthy.Load())
+		require.False(t, down.healthy.Load())
+
+		for i := 0; i < 3; i++ {
+			require.Same(t, ok.pool, rs.pick())
+		}
+	})
+
+	t.Run("start checks replicas before returning", func(t *testing.T) {
+		rs := newReplicaSet()
+		rs.checkPeriod = time.Hour
+		down := fakeReplica(0, errors.New("connection refused"), 0)
+		rs.pools = []*replica{down}
+
+		rs.start()
+		defer func() {
+			close(rs.stop)
+			rs.wg.Wait()
+		}()
+
+		require.False(t, down.healthy.Load())
+		require.Nil(t, rs.pick())
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		rs := newReplicaSet()
+		a, b := fakeReplica(0, nil, 0), fakeReplica(0, nil, 0)
+		rs.pools = []*replica{a, b}
+		rs.check()
+
+		require.Same(t, a.pool, rs.pick())
+		require.Same(t, b.pool, rs.pick())
+		require.Same(t, a.pool, rs.pick())
+	})
+
+	t.Run("least connections", func(t *testing.T) {
+		rs := newReplicaSet()
+		rs.balancer = LeastConnections
+		busy, idle, unhealthy := fakeReplica(0, nil, 5), fakeReplica(0, nil, 2), fakeReplica(0, errors.New("timeout"), 0)
+		rs.pools = []*replica{busy, unhealthy, idle}
+		rs.check()
+
+		require.Same(t, idle.pool, rs.pick())
+		require.Same(t, idle.pool, rs.pick())
+	})
+
+	t.Run("close twice", func(t *testing.T) {
+		config, err := pgxpool.ParseConfig("postgres://localhost:5432/test")
+		require.NoError(t, err)
+		// lazy pools don't connect, so closing doesn't need a server
+		config.LazyConnect = true
+		pool, err := pgxpool.ConnectConfig(context.Background(), config)
+		require.NoError(t, err)
+
+		rs := newReplicaSet()
+		rs.checkPeriod = time.Hour
+		r := fakeReplica(0, nil, 0)
+		r.pool = pool
+		rs.pools = []*replica{r}
+		rs.start()
+
+		rs.close()
+		rs.close()
+		require.Zero(t, pool.Stat().TotalConns())
+	})
+
+	t.Run("lag query checks the wal receiver", func(t *testing.T) {
+		// equal LSNs alone don't mean the replica is caught up when the upstream is lost
+		require.Contains(t, replicationLagQuery, "pg_stat_wal_receiver WHERE status = 'streaming'")
+		require.Contains(t, replicationLagQuery, "pg_last_xact_replay_timestamp()")
+	})
+}
+
+func TestPool(t *testing.T) {
+	primary := &pgxpool.Pool{}
+	rs := newReplicaSet()
+	r := fakeReplica(0, nil, 0)
+	rs.pools = []*replica{r}
+	rs.check()
+	p := &pg{dbc: primary, replicas: rs}
+
+	ctx := context.Background()
+	require.Same(t, primary, p.pool(ctx, db.Query{}))
+	require.Same(t, r.pool, p.pool(ctx, db.Query{ReadOnly: true}))
+	require.Same(t, r.pool, p.pool(WithReadOnly(ctx), db.Query{}))
+
+	t.Run("falls back to the primary without healthy replicas", func(t *testing.T) {
+		r.healthy.Store(false)
+		require.Same(t, primary, p.pool(ctx, db.Query{ReadOnly: true}))
+	})
+
+	t.Run("falls back to the primary without replicas", func(t *testing.T) {
+		p := &pg{dbc: primary, replicas: newReplicaSet()}
+		require.Same(t, primary, p.pool(WithReadOnly(ctx), db.Query{}))
+	})
+}
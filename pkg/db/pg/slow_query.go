@@ -0,0 +1,215 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/prettier"
+)
+
+const defaultExplainTimeout = 5 * time.Second
+
+// SlowQuery describes a query that exceeded the slow-query threshold
+type SlowQuery struct {
+	Name     string
+	Query    string
+	Duration time.Duration
+	InTx     bool
+	Err      error
+	// Plan is the EXPLAIN (FORMAT JSON) output, filled only when explain is enabled
+	Plan       string
+	ExplainErr error
+}
+
+// SlowQueryLogFunc receives slow queries
+type SlowQueryLogFunc func(ctx context.Context, q SlowQuery)
+
+type slowQueryHook struct {
+	threshold      time.Duration
+	logFunc        SlowQueryLogFunc
+	sampleRate     float64
+	limiter        *rateLimiter
+	explainPool    *pgxpool.Pool
+	explainTimeout time.Duration
+	explainSem     chan struct{}
+}
+
+// SlowQueryOption configures the slow-query hook
+type SlowQueryOption func(*slowQueryHook)
+
+// WithSlowQueryLogFunc sets the function that receives slow queries, log.Println is used by default
+func WithSlowQueryLogFunc(f SlowQueryLogFunc) SlowQueryOption {
+	return func(h *slowQueryHook) {
+		h.logFunc = f
+	}
+}
+
+// WithSampleRate logs only the given fraction (0..1] of slow queries
+func WithSampleRate(rate float64) SlowQueryOption {
+	return func(h *slowQueryHook) {
+		h.sampleRate = rate
+	}
+}
+
+// WithRateLimit logs at most n slow queries per interval, the rest are dropped
+func WithRateLimit(n int, per time.Duration) SlowQueryOption {
+	return func(h *slowQueryHook) {
+		h.limiter = &rateLimiter{limit: n, per: per}
+	}
+}
+
+// WithExplain enables debug mode: slow queries are logged together with their EXPLAIN (FORMAT JSON) plan.
+// Plans are captured asynchronously on a separate connection from pool, at most maxConcurrent at a time.
+// The plan is never captured inside the user's transaction.
+func WithExplain(pool *pgxpool.Pool, maxConcurrent int) SlowQueryOption {
+	return func(h *slowQueryHook) {
+		if maxConcurrent < 1 {
+			maxConcurrent = 1
+		}
+		h.explainPool = pool
+		h.explainSem = make(chan struct{}, maxConcurrent)
+	}
+}
+
+// WithExplainTimeout sets the timeout for capturing a plan
+func WithExplainTimeout(d time.Duration) SlowQueryOption {
+	return func(h *slowQueryHook) {
+		h.explainTimeout = d
+	}
+}
+
+// NewSlowQueryHook returns a hook that logs queries running longer than threshold
+func NewSlowQueryHook(threshold time.Duration, opts ...SlowQueryOption) db.Hook {
+	h := &slowQueryHook{
+		threshold:      threshold,
+		sampleRate:     1,
+		explainTimeout: defaultExplainTimeout,
+		logFunc: func(ctx context.Context, q SlowQuery) {
+			log.Println(
+				ctx,
+				fmt.Sprintf("slow sql: %s", q.Name),
+				fmt.Sprintf("duration: %s", q.Duration),
+				fmt.Sprintf("query: %s", q.Query),
+				fmt.Sprintf("plan: %s", q.Plan),
+			)
+		},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *slowQueryHook) Before(ctx context.Context, e *db.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *slowQueryHook) After(ctx context.Context, e *db.QueryEvent) {
+	if e.Duration < h.threshold {
+		return
+	}
+
+	if h.sampleRate < 1 && rand.Float64() >= h.sampleRate {
+		return
+	}
+
+	if h.limiter != nil && !h.limiter.allow() {
+		return
+	}
+
+	sq := SlowQuery{
+		Name:     e.Query.Name,
+		Query:    prettier.Pretty(e.Query.QueryRaw, prettier.PlaceholderDollar, e.Args...),
+		Duration: e.Duration,
+		InTx:     e.InTx,
+		Err:      e.Err,
+	}
+
+	if h.explainPool == nil || !explainable(e.Query.QueryRaw) {
+		h.logFunc(ctx, sq)
+		return
+	}
+
+	select {
+	case h.explainSem <- struct{}{}:
+	default:
+		// too many plans are being captured already, log without a plan
+		h.logFunc(ctx, sq)
+		return
+	}
+
+	// the user's context may be canceled or hold a finished tx, so the plan is captured detached from it
+	args := append([]interface{}(nil), e.Args...)
+	go func() {
+		defer func() { <-h.explainSem }()
+
+		explainCtx, cancel := context.WithTimeout(context.Background(), h.explainTimeout)
+		defer cancel()
+
+		sq.Plan, sq.ExplainErr = h.explain(explainCtx, e.Query.QueryRaw, args)
+		h.logFunc(ctx, sq)
+	}()
+}
+
+func (h *slowQueryHook) explain(ctx context.Context, query string, args []interface{}) (string, error) {
+	conn, err := h.explainPool.Acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Release()
+
+	var plan string
+	err = conn.QueryRow(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&plan)
+	return plan, err
+}
+
+// explainable reports whether EXPLAIN supports the statement
+func explainable(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "VALUES", "TABLE":
+		return true
+	}
+
+	return false
+}
+
+// rateLimiter allows up to limit events per fixed window
+type rateLimiter struct {
+	mu          sync.Mutex
+	limit       int
+	per         time.Duration
+	windowStart time.Time
+	count       int
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) >= l.per {
+		l.windowStart = now
+		l.count = 0
+	}
+
+	if l.count >= l.limit {
+		return false
+	}
+
+	l.count++
+	return true
+}
@@ -0,0 +1,121 @@
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+func TestSlowQueryHook(t *testing.T) {
+	newHook := func(threshold time.Duration, opts ...SlowQueryOption) (db.Hook, *[]SlowQuery) {
+		var logged []SlowQuery
+		opts = append(opts, WithSlowQueryLogFunc(func(ctx context.Context, q SlowQuery) {
+			logged = append(logged, q)
+		}))
+
+		return NewSlowQueryHook(threshold, opts...), &logged
+	}
+
+	observe := func(hook db.Hook, q db.Query, d time.Duration, args ...interface{}) {
+		e := &db.QueryEvent{Query: q, Args: args}
+		ctx := hook.Before(context.Background(), e)
+		e.Duration = d
+		hook.After(ctx, e)
+	}
+
+	t.Run("threshold", func(t *testing.T) {
+		hook, logged := newHook(100 * time.Millisecond)
+
+		observe(hook, db.Query{Name: "user.Fast", QueryRaw: "SELECT 1"}, 99*time.Millisecond)
+		observe(hook, db.Query{Name: "user.Slow", QueryRaw: "SELECT * FROM users WHERE id = $1"}, 100*time.Millisecond, 42)
+
+		require.Len(t, *logged, 1)
+		require.Equal(t, "user.Slow", (*logged)[0].Name)
+		require.Equal(t, 100*time.Millisecond, (*logged)[0].Duration)
+		require.Contains(t, (*logged)[0].Query, "42")
+		require.Empty(t, (*logged)[0].Plan)
+	})
+
+	t.Run("sample rate", func(t *testing.T) {
+		q := db.Query{Name: "user.Slow", QueryRaw: "SELECT 1"}
+
+		hook, logged := newHook(0, WithSampleRate(0))
+		for i := 0; i < 100; i++ {
+			observe(hook, q, time.Second)
+		}
+		require.Empty(t, *logged)
+
+		hook, logged = newHook(0, WithSampleRate(0.5))
+		for i := 0; i < 1000; i++ {
+			observe(hook, q, time.Second)
+		}
+		require.Greater(t, len(*logged), 300)
+		require.Less(t, len(*logged), 700)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		hook, logged := newHook(0, WithRateLimit(2, time.Hour))
+		for i := 0; i < 5; i++ {
+			observe(hook, db.Query{Name: "user.Slow", QueryRaw: "SELECT 1"}, time.Second)
+		}
+
+		require.Len(t, *logged, 2)
+	})
+
+	t.Run("explain semaphore is full", func(t *testing.T) {
+		hook, logged := newHook(0, WithExplain(&pgxpool.Pool{}, 1))
+		hook.(*slowQueryHook).explainSem <- struct{}{}
+
+		observe(hook, db.Query{Name: "user.Slow", QueryRaw: "SELECT 1"}, time.Second)
+
+		require.Len(t, *logged, 1)
+		require.Empty(t, (*logged)[0].Plan)
+		require.NoError(t, (*logged)[0].ExplainErr)
+	})
+
+	t.Run("statements without plan", func(t *testing.T) {
+		hook, logged := newHook(0, WithExplain(&pgxpool.Pool{}, 1))
+
+		observe(hook, db.Query{Name: "migrate.Lock", QueryRaw: "LOCK TABLE users"}, time.Second)
+
+		require.Len(t, *logged, 1)
+		require.Len(t, hook.(*slowQueryHook).explainSem, 0)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{limit: 2, per: time.Minute}
+
+	require.True(t, l.allow())
+	require.True(t, l.allow())
+	require.False(t, l.allow())
+
+	// the next window starts over
+	l.windowStart = l.windowStart.Add(-time.Minute)
+	require.True(t, l.allow())
+	require.True(t, l.allow())
+	require.False(t, l.allow())
+}
+
+func TestExplainable(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT 1":                             true,
+		"  select * from users":                true,
+		"WITH x AS (SELECT 1) SELECT * FROM x": true,
+		"INSERT INTO users DEFAULT VALUES":     true,
+		"update users SET name = $1":           true,
+		"DELETE FROM users":                    true,
+		"VALUES (1)":                           true,
+		"TABLE users":                          true,
+		"CREATE TABLE users (id int)":          false,
+		"LOCK TABLE users":                     false,
+		"COPY users FROM STDIN":                false,
+		"":                                     false,
+	} {
+		require.Equal(t, want, explainable(query), query)
+	}
+}
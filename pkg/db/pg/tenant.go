@@ -0,0 +1,225 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+const (
+	tenantBypassKey key = "tenant_bypass"
+
+	defaultTenantSetting = "app.tenant_id"
+)
+
+// ErrNoTenant is returned when tenancy is enabled and ctx has no tenant
+var ErrNoTenant = sys.NewError("tenant is not set", codes.PermissionDenied)
+
+// TenantMode selects how queries are isolated between tenants
+type TenantMode int
+
+const (
+	// TenantSchema runs queries with search_path set to the tenant schema followed by public
+	TenantSchema TenantMode = iota + 1
+	// TenantRLS runs queries with the tenant id in the app.tenant_id setting for row-level security policies,
+	// for example USING (tenant_id = current_setting('app.tenant_id'))
+	TenantRLS
+)
+
+// TenantResolver returns the tenant of the request
+type TenantResolver func(ctx context.Context) (string, bool)
+
+type tenancy struct {
+	mode    TenantMode
+	schema  func(tenantID string) string
+	setting string
+	resolve TenantResolver
+}
+
+// TenancyOption configures tenancy
+type TenancyOption func(*tenancy)
+
+// WithTenantSchema sets the function that returns the schema of a tenant, the tenant id itself by default
+func WithTenantSchema(fn func(tenantID string) string) TenancyOption {
+	return func(t *tenancy) {
+		t.schema = fn
+	}
+}
+
+// WithTenantSetting sets the name of the setting used in RLS mode, app.tenant_id by default
+func WithTenantSetting(name string) TenancyOption {
+	return func(t *tenancy) {
+		t.setting = name
+	}
+}
+
+// WithTenantResolver sets how the tenant is read from ctx, db.TenantFromContext by default
+func WithTenantResolver(fn TenantResolver) TenancyOption {
+	return func(t *tenancy) {
+		t.resolve = fn
+	}
+}
+
+// WithTenancy isolates every query by tenant, the tenant is set with db.WithTenant. The tenant settings are applied with SET LOCAL semantics
+// when a transaction begins, queries outside a transaction are wrapped in one.
+// Queries without a tenant in ctx fail with ErrNoTenant unless ctx is marked with WithoutTenant.
+func WithTenancy(mode TenantMode, opts ...TenancyOption) Option {
+	return func(p *pg) {
+		t := &tenancy{
+			mode:    mode,
+			schema:  func(tenantID string) string { return tenantID },
+			setting: defaultTenantSetting,
+			resolve: db.TenantFromContext,
+		}
+		for _, opt := range opts {
+			opt(t)
+		}
+
+		p.tenancy = t
+	}
+}
+
+// WithoutTenant returns a context for queries that are not isolated, like migrations or cross-tenant jobs
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantBypassKey, true)
+}
+
+// tenant returns the tenant to apply, an empty tenant means the queries are not isolated
+func (t *tenancy) tenant(ctx context.Context) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	if bypass, _ := ctx.Value(tenantBypassKey).(bool); bypass {
+		return "", nil
+	}
+
+	tenantID, ok := t.resolve(ctx)
+	if !ok || tenantID == "" {
+		return "", ErrNoTenant
+	}
+
+	return tenantID, nil
+}
+
+// wraps reports whether a query has to be wrapped in a tenant transaction
+func (t *tenancy) wraps(ctx context.Context) bool {
+	if t == nil {
+		return false
+	}
+	if _, inTx := ctx.Value(TxKey).(pgx.Tx); inTx {
+		return false
+	}
+	bypass, _ := ctx.Value(tenantBypassKey).(bool)
+
+	return !bypass
+}
+
+// apply sets the tenant until the end of the transaction
+func (t *tenancy) apply(ctx context.Context, tx pgx.Tx, tenantID string) error {
+	var err error
+	switch t.mode {
+	case TenantSchema:
+		searchPath := pgx.Identifier{t.schema(tenantID)}.Sanitize() + ", public"
+		_, err = tx.Exec(ctx, "SELECT set_config('search_path', $1, true)", searchPath)
+	case TenantRLS:
+		_, err = tx.Exec(ctx, "SELECT set_config($1, $2, true)", t.setting, tenantID)
+	default:
+		err = errors.Errorf("unknown tenant mode %d", t.mode)
+	}
+
+	return errors.Wrapf(err, "can't set tenant %s", tenantID)
+}
+
+// txBeginner begins transactions, a *pgxpool.Pool
+type txBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// begin starts a transaction on pool with the tenant from ctx applied
+func (p *pg) begin(ctx context.Context, pool txBeginner, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	tenantID, err := p.tenancy.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pool.BeginTx(ctx, txOptions)
+	if err != nil || tenantID == "" {
+		return tx, err
+	}
+
+	if err = p.tenancy.apply(ctx, tx, tenantID); err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// inTenantTx runs fn in a tenant transaction on pool that is committed when fn succeeds.
+// When the transaction can't begin, fn doesn't run and the error is passed to failed.
+func (p *pg) inTenantTx(ctx context.Context, pool txBeginner, failed func(err error) error, fn func(ctx context.Context) error) error {
+	tx, err := p.begin(ctx, pool, pgx.TxOptions{})
+	if err != nil {
+		return failed(err)
+	}
+
+	return endTenantTx(ctx, tx, fn(MakeContextTx(ctx, tx)))
+}
+
+// tenantRows runs the query in a tenant transaction on pool that ends when the rows are closed
+func (p *pg) tenantRows(ctx context.Context, pool txBeginner, q db.Query, args []interface{}) (pgx.Rows, error) {
+	tx, err := p.begin(ctx, pool, pgx.TxOptions{})
+	if err != nil {
+		return nil, p.failed(ctx, db.OpQuery, q, args)(err)
+	}
+
+	rows, err := p.QueryContext(MakeContextTx(ctx, tx), q, args...)
+	if err != nil {
+		return nil, endTenantTx(ctx, tx, err)
+	}
+
+	return &hookRows{
+		Rows: rows,
+		finish: func(err error) error {
+			return endTenantTx(ctx, tx, err)
+		},
+	}, nil
+}
+
+// tenantRow runs the query in a tenant transaction on pool that ends when the row is scanned
+func (p *pg) tenantRow(ctx context.Context, pool txBeginner, q db.Query, args []interface{}) pgx.Row {
+	tx, err := p.begin(ctx, pool, pgx.TxOptions{})
+	if err != nil {
+		return errRow{err: p.failed(ctx, db.OpQueryRow, q, args)(err)}
+	}
+
+	return &hookRow{
+		Row: p.QueryRowContext(MakeContextTx(ctx, tx), q, args...),
+		finish: func(err error) error {
+			return endTenantTx(ctx, tx, err)
+		},
+	}
+}
+
+// failed returns a function that reports a query that failed before it ran to the hooks,
+// so tracing, metrics and logging see it like any other failed query
+func (p *pg) failed(ctx context.Context, op string, q db.Query, args []interface{}) func(err error) error {
+	return func(err error) error {
+		ctx, e := p.before(ctx, op, q, args)
+		return p.after(ctx, e, err)
+	}
+}
+
+// endTenantTx commits the tenant transaction, or rolls it back when the query failed
+func endTenantTx(ctx context.Context, tx pgx.Tx, err error) error {
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(ctx), "can't commit tenant transaction")
+}
@@ -0,0 +1,327 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// fakeTx only marks ctx as being in a transaction
+type fakeTx struct {
+	pgx.Tx
+}
+
+func TestTenancy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("disabled", func(t *testing.T) {
+		p := &pg{}
+
+		tenantID, err := p.tenancy.tenant(ctx)
+		require.NoError(t, err)
+		require.Empty(t, tenantID)
+		require.False(t, p.tenancy.wraps(ctx))
+	})
+
+	t.Run("tenant from context", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS)(p)
+
+		tenantID, err := p.tenancy.tenant(db.WithTenant(ctx, "acme"))
+		require.NoError(t, err)
+		require.Equal(t, "acme", tenantID)
+		require.True(t, p.tenancy.wraps(ctx))
+		require.False(t, p.tenancy.wraps(MakeContextTx(ctx, fakeTx{})))
+	})
+
+	t.Run("missing tenant", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantSchema)(p)
+
+		_, err := p.tenancy.tenant(ctx)
+		require.ErrorIs(t, err, ErrNoTenant)
+		require.Equal(t, codes.PermissionDenied, sys.GetError(err).Code())
+	})
+
+	t.Run("bypass", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantSchema)(p)
+
+		ctx := WithoutTenant(ctx)
+		tenantID, err := p.tenancy.tenant(ctx)
+		require.NoError(t, err)
+		require.Empty(t, tenantID)
+		require.False(t, p.tenancy.wraps(ctx))
+	})
+
+	t.Run("custom resolver", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantSchema, WithTenantResolver(func(ctx context.Context) (string, bool) {
+			return "tenant_42", true
+		}))(p)
+
+		tenantID, err := p.tenancy.tenant(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "tenant_42", tenantID)
+	})
+}
+
+// tenantTx records the statements of a tenant transaction and how it ended
+type tenantTx struct {
+	pgx.Tx
+	execs      [][]interface{}
+	values     []int64
+	queryErr   error
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *tenantTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.execs = append(tx.execs, append([]interface{}{sql}, args...))
+	return pgconn.CommandTag("SELECT 1"), nil
+}
+
+func (tx *tenantTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if tx.queryErr != nil {
+		return nil, tx.queryErr
+	}
+
+	return &fakeRows{values: tx.values}, nil
+}
+
+func (tx *tenantTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &fakeRows{values: tx.values, err: tx.queryErr}
+}
+
+func (tx *tenantTx) Commit(ctx context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *tenantTx) Rollback(ctx context.Context) error {
+	tx.rolledBack = true
+	return nil
+}
+
+// fakeRows returns one int64 column, as a row it scans the first value
+type fakeRows struct {
+	pgx.Rows
+	values []int64
+	next   int
+	err    error
+}
+
+func (r *fakeRows) Next() bool {
+	if r.next >= len(r.values) {
+		return false
+	}
+
+	r.next++
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.next == 0 {
+		if len(r.values) == 0 {
+			return pgx.ErrNoRows
+		}
+		r.next++
+	}
+
+	*dest[0].(*int64) = r.values[r.next-1]
+	return nil
+}
+
+func (r *fakeRows) Err() error                    { return r.err }
+func (r *fakeRows) Close()                        {}
+func (r *fakeRows) CommandTag() pgconn.CommandTag { return pgconn.CommandTag("SELECT 1") }
+
+type beginFunc func(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+
+func (f beginFunc) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	return f(ctx, txOptions)
+}
+
+// beginTx returns a beginner that begins tx and counts the calls
+func beginTx(tx pgx.Tx, calls *int) beginFunc {
+	return func(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+		*calls++
+		return tx, nil
+	}
+}
+
+func TestTenantTransaction(t *testing.T) {
+	ctx := db.WithTenant(context.Background(), "acme")
+
+	t.Run("apply schema", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantSchema, WithTenantSchema(func(tenantID string) string { return "t_" + tenantID }))(p)
+		tx := &tenantTx{}
+
+		require.NoError(t, p.tenancy.apply(ctx, tx, "acme"))
+		require.Equal(t, [][]interface{}{{"SELECT set_config('search_path', $1, true)", `"t_acme", public`}}, tx.execs)
+	})
+
+	t.Run("apply RLS", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS, WithTenantSetting("app.org"))(p)
+		tx := &tenantTx{}
+
+		require.NoError(t, p.tenancy.apply(ctx, tx, "acme"))
+		require.Equal(t, [][]interface{}{{"SELECT set_config($1, $2, true)", "app.org", "acme"}}, tx.execs)
+
+		p.tenancy.mode = 0
+		require.Error(t, p.tenancy.apply(ctx, tx, "acme"))
+	})
+
+	t.Run("begin", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS)(p)
+		tx := &tenantTx{}
+		calls := 0
+
+		got, err := p.begin(ctx, beginTx(tx, &calls), pgx.TxOptions{})
+		require.NoError(t, err)
+		require.Same(t, tx, got)
+		require.Len(t, tx.execs, 1)
+
+		// without a tenant no transaction is started
+		_, err = p.begin(context.Background(), beginTx(tx, &calls), pgx.TxOptions{})
+		require.ErrorIs(t, err, ErrNoTenant)
+		require.Equal(t, 1, calls)
+
+		beginErr := errors.New("pool closed")
+		_, err = p.begin(ctx, beginFunc(func(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+			return nil, beginErr
+		}), pgx.TxOptions{})
+		require.ErrorIs(t, err, beginErr)
+	})
+
+	t.Run("in tenant transaction", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS)(p)
+		calls := 0
+		failed := func(err error) error { return err }
+
+		tx := &tenantTx{}
+		err := p.inTenantTx(ctx, beginTx(tx, &calls), failed, func(ctx context.Context) error {
+			_, inTx := ctx.Value(TxKey).(pgx.Tx)
+			require.True(t, inTx)
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, tx.committed)
+
+		tx = &tenantTx{}
+		fnErr := errors.New("query failed")
+		err = p.inTenantTx(ctx, beginTx(tx, &calls), failed, func(ctx context.Context) error {
+			return fnErr
+		})
+		require.ErrorIs(t, err, fnErr)
+		require.True(t, tx.rolledBack)
+		require.False(t, tx.committed)
+	})
+
+	t.Run("rows end the transaction when closed", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS)(p)
+		calls := 0
+
+		tx := &tenantTx{values: []int64{1, 2}}
+		rows, err := p.tenantRows(ctx, beginTx(tx, &calls), db.Query{Name: "user.List"}, nil)
+		require.NoError(t, err)
+
+		var got []int64
+		for rows.Next() {
+			var v int64
+			require.NoError(t, rows.Scan(&v))
+			got = append(got, v)
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, []int64{1, 2}, got)
+		require.True(t, tx.committed)
+
+		// an early Close commits as well, the transaction only read
+		tx = &tenantTx{values: []int64{1, 2}}
+		rows, err = p.tenantRows(ctx, beginTx(tx, &calls), db.Query{Name: "user.List"}, nil)
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		rows.Close()
+		require.True(t, tx.committed)
+
+		queryErr := errors.New("syntax error")
+		tx = &tenantTx{queryErr: queryErr}
+		_, err = p.tenantRows(ctx, beginTx(tx, &calls), db.Query{Name: "user.List"}, nil)
+		require.ErrorIs(t, err, queryErr)
+		require.True(t, tx.rolledBack)
+	})
+
+	t.Run("row ends the transaction when scanned", func(t *testing.T) {
+		p := &pg{}
+		WithTenancy(TenantRLS)(p)
+		calls := 0
+
+		tx := &tenantTx{values: []int64{42}}
+		var v int64
+		require.NoError(t, p.tenantRow(ctx, beginTx(tx, &calls), db.Query{Name: "user.Count"}, nil).Scan(&v))
+		require.Equal(t, int64(42), v)
+		require.True(t, tx.committed)
+
+		tx = &tenantTx{}
+		err := p.tenantRow(ctx, beginTx(tx, &calls), db.Query{Name: "user.Count"}, nil).Scan(&v)
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+		require.True(t, tx.rolledBack)
+	})
+}
+
+func TestTenantBeginErrorHooks(t *testing.T) {
+	var events []db.QueryEvent
+	p := &pg{replicas: newReplicaSet()}
+	WithTenancy(TenantRLS)(p)
+	WithHooks(db.HookFuncs{
+		AfterFunc: func(ctx context.Context, e *db.QueryEvent) {
+			events = append(events, *e)
+		},
+	})(p)
+
+	// no tenant in ctx, so the tenant transaction can't begin
+	ctx := context.Background()
+	q := db.Query{Name: "user.Get"}
+	var dest struct{}
+
+	require.ErrorIs(t, p.ScanOneContext(ctx, &dest, q), ErrNoTenant)
+	require.ErrorIs(t, p.ScanAllContext(ctx, &dest, q), ErrNoTenant)
+	_, err := p.ExecContext(ctx, q)
+	require.ErrorIs(t, err, ErrNoTenant)
+	_, err = p.QueryContext(ctx, q)
+	require.ErrorIs(t, err, ErrNoTenant)
+	require.ErrorIs(t, p.QueryRowContext(ctx, q).Scan(&dest), ErrNoTenant)
+
+	b := &db.Batch{}
+	b.Queue(db.Query{Name: "user.Create"})
+	b.Queue(db.Query{Name: "user.Rename"})
+	_, err = p.SendBatchContext(ctx, b)
+	require.ErrorIs(t, err, ErrNoTenant)
+
+	_, err = p.CopyFromContext(ctx, "user.Import", pgx.Identifier{"users"}, []string{"name"}, pgx.CopyFromRows(nil))
+	require.ErrorIs(t, err, ErrNoTenant)
+
+	var ops []string
+	for _, e := range events {
+		require.ErrorIs(t, e.Err, ErrNoTenant)
+		ops = append(ops, e.Operation)
+	}
+	require.Equal(t, []string{
+		db.OpScanOne, db.OpScanAll, db.OpExec, db.OpQuery, db.OpQueryRow, db.OpBatch, db.OpBatch, db.OpCopyFrom,
+	}, ops)
+}
@@ -0,0 +1,108 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// WithDefaultTimeout sets the timeout for queries without their own db.Query.Timeout
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(p *pg) {
+		p.defaultTimeout = d
+	}
+}
+
+// timeout returns the effective timeout of the query, zero means no timeout
+func (p *pg) timeout(q db.Query) time.Duration {
+	switch {
+	case q.Timeout > 0:
+		return q.Timeout
+	case q.Timeout < 0:
+		return 0
+	default:
+		return p.defaultTimeout
+	}
+}
+
+// deadline derives the query context with the effective timeout.
+// Inside a transaction the timeout is also set as the local statement_timeout, so the server stops
+// the statement even if the client is stuck. The returned release func cancels the context and
+// restores the previous statement_timeout, so the timeout doesn't leak to later statements of the transaction.
+// This costs a round trip before the statement and, when the setting changed, one after it,
+// use NoTimeout for hot statements in transactions that don't need a server side limit.
+func (p *pg) deadline(ctx context.Context, q db.Query) (context.Context, func(), time.Duration, error) {
+	d := p.timeout(q)
+	if d <= 0 {
+		return ctx, func() {}, 0, nil
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, d)
+	tx, ok := ctx.Value(TxKey).(pgx.Tx)
+	if !ok {
+		return qctx, cancel, d, nil
+	}
+
+	prev, current, err := setStatementTimeout(qctx, tx, d)
+	if err != nil {
+		cancel()
+		return ctx, func() {}, d, timeoutError(ctx, q, d, err)
+	}
+	if prev == current {
+		return qctx, cancel, d, nil
+	}
+
+	return qctx, func() {
+		cancel()
+		// fails only when the transaction is already aborted, then there is nothing to restore
+		_, _ = tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true)", prev)
+	}, d, nil
+}
+
+// setStatementTimeout sets statement_timeout until the end of the transaction and returns the previous and new values.
+// The materialized CTE guarantees the previous value is read before it is replaced.
+func setStatementTimeout(ctx context.Context, tx pgx.Tx, d time.Duration) (string, string, error) {
+	// statement_timeout has millisecond precision and 0 disables it
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	var prev, current string
+	err := tx.QueryRow(ctx, `WITH prev AS MATERIALIZED (SELECT current_setting('statement_timeout') AS value)
+SELECT value, set_config('statement_timeout', $1, true) FROM prev`, fmt.Sprintf("%dms", ms)).Scan(&prev, &current)
+
+	return prev, current, err
+}
+
+// timeoutError returns a DeadlineExceeded sys error when the query failed because of its own timeout.
+// Errors caused by the parent context are returned as is.
+func timeoutError(parent context.Context, q db.Query, d time.Duration, err error) error {
+	if err == nil || d <= 0 || parent.Err() != nil {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	isStatementTimeout := errors.As(err, &pgErr) && codeFromPgError(pgErr) == codes.DeadlineExceeded
+	if !isStatementTimeout && !errors.Is(err, context.DeadlineExceeded) && !pgconn.Timeout(err) {
+		return err
+	}
+
+	return sys.NewError(fmt.Sprintf("query %s timed out after %s", q.Name, d), codes.DeadlineExceeded)
+}
+
+// errRow is returned by QueryRowContext when the query could not be started
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...interface{}) error {
+	return r.err
+}
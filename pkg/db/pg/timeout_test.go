@@ -0,0 +1,172 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+func TestTimeout(t *testing.T) {
+	p := &pg{}
+	WithDefaultTimeout(time.Second)(p)
+
+	require.Equal(t, time.Second, p.timeout(db.Query{}))
+	require.Equal(t, time.Minute, p.timeout(db.Query{Timeout: time.Minute}))
+	require.Zero(t, p.timeout(db.Query{Timeout: -1}))
+
+	t.Run("deadline without timeout", func(t *testing.T) {
+		ctx := context.Background()
+		qctx, cancel, d, err := (&pg{}).deadline(ctx, db.Query{})
+		defer cancel()
+
+		require.NoError(t, err)
+		require.Zero(t, d)
+		require.Equal(t, ctx, qctx)
+	})
+
+	t.Run("deadline with timeout", func(t *testing.T) {
+		qctx, cancel, d, err := p.deadline(context.Background(), db.Query{})
+		defer cancel()
+
+		require.NoError(t, err)
+		require.Equal(t, time.Second, d)
+		_, ok := qctx.Deadline()
+		require.True(t, ok)
+	})
+}
+
+// timeoutTx simulates the statement_timeout setting of a transaction
+type timeoutTx struct {
+	pgx.Tx
+	setting string
+	calls   int
+}
+
+func (tx *timeoutTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	tx.calls++
+	prev := tx.setting
+	tx.setting = args[0].(string)
+	return settingRow{prev: prev, current: tx.setting}
+}
+
+func (tx *timeoutTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.calls++
+	tx.setting = args[0].(string)
+	return pgconn.CommandTag("SELECT 1"), nil
+}
+
+type settingRow struct {
+	prev, current string
+}
+
+func (r settingRow) Scan(dest ...interface{}) error {
+	*dest[0].(*string) = r.prev
+	*dest[1].(*string) = r.current
+	return nil
+}
+
+func TestTimeoutInTx(t *testing.T) {
+	p := &pg{}
+	tx := &timeoutTx{setting: "0"}
+	ctx := MakeContextTx(context.Background(), tx)
+
+	t.Run("timeout is restored after the statement", func(t *testing.T) {
+		_, release, d, err := p.deadline(ctx, db.Query{Timeout: 100 * time.Millisecond})
+		require.NoError(t, err)
+		require.Equal(t, 100*time.Millisecond, d)
+		require.Equal(t, "100ms", tx.setting)
+
+		release()
+		require.Equal(t, "0", tx.setting)
+	})
+
+	t.Run("outer timeout of the transaction is kept", func(t *testing.T) {
+		tx.setting = "5s"
+
+		_, release, _, err := p.deadline(ctx, db.Query{Timeout: 300 * time.Microsecond})
+		require.NoError(t, err)
+		require.Equal(t, "1ms", tx.setting)
+
+		release()
+		require.Equal(t, "5s", tx.setting)
+	})
+
+	t.Run("unchanged setting is not restored", func(t *testing.T) {
+		tx.setting = "100ms"
+		tx.calls = 0
+
+		_, release, _, err := p.deadline(ctx, db.Query{Timeout: 100 * time.Millisecond})
+		require.NoError(t, err)
+		release()
+
+		require.Equal(t, 1, tx.calls)
+		require.Equal(t, "100ms", tx.setting)
+	})
+
+	t.Run("queries without timeout don't touch the setting", func(t *testing.T) {
+		tx.calls = 0
+
+		_, release, _, err := p.deadline(ctx, db.Query{Timeout: -1})
+		require.NoError(t, err)
+		release()
+
+		_, release, _, err = p.deadline(ctx, db.Query{})
+		require.NoError(t, err)
+		release()
+
+		require.Zero(t, tx.calls)
+	})
+}
+
+func TestBatchTimeout(t *testing.T) {
+	p := &pg{}
+	WithDefaultTimeout(time.Second)(p)
+
+	q := p.batchTimeout([]db.BatchItem{{Query: db.Query{}}, {Query: db.Query{Timeout: time.Minute}}})
+	require.Equal(t, time.Minute+time.Second, q.Timeout)
+
+	q = p.batchTimeout([]db.BatchItem{{Query: db.Query{}}, {Query: db.Query{Timeout: -1}}})
+	require.Zero(t, p.timeout(q))
+}
+
+func TestTimeoutError(t *testing.T) {
+	q := db.Query{Name: "report.Build"}
+	ctx := context.Background()
+
+	for name, err := range map[string]error{
+		"context deadline":  fmt.Errorf("query: %w", context.DeadlineExceeded),
+		"statement timeout": &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := timeoutError(ctx, q, time.Second, err)
+
+			sysErr := sys.GetError(err)
+			require.NotNil(t, sysErr)
+			require.Equal(t, codes.DeadlineExceeded, sysErr.Code())
+			require.Contains(t, err.Error(), "report.Build")
+		})
+	}
+
+	t.Run("other errors are kept", func(t *testing.T) {
+		err := errors.New("syntax error")
+		require.Equal(t, err, timeoutError(ctx, q, time.Second, err))
+		require.Equal(t, context.DeadlineExceeded, timeoutError(ctx, q, 0, context.DeadlineExceeded))
+	})
+
+	t.Run("parent context errors are kept", func(t *testing.T) {
+		parent, cancel := context.WithTimeout(ctx, time.Nanosecond)
+		defer cancel()
+		<-parent.Done()
+
+		require.Equal(t, context.DeadlineExceeded, timeoutError(parent, q, time.Second, context.DeadlineExceeded))
+	})
+}
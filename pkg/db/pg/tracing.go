@@ -0,0 +1,92 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/prettier"
+)
+
+type spanKey struct{}
+
+type tracingHook struct {
+	tracer opentracing.Tracer
+	pretty bool
+}
+
+// TracingOption configures the tracing hook
+type TracingOption func(*tracingHook)
+
+// WithTracer sets the tracer used to start spans, opentracing.GlobalTracer() by default
+func WithTracer(tracer opentracing.Tracer) TracingOption {
+	return func(h *tracingHook) {
+		h.tracer = tracer
+	}
+}
+
+// WithPrettyStatement puts the query with substituted args into the db.statement tag
+func WithPrettyStatement() TracingOption {
+	return func(h *tracingHook) {
+		h.pretty = true
+	}
+}
+
+// NewTracingHook returns a hook that starts a child span for every query, named after Query.Name
+func NewTracingHook(opts ...TracingOption) db.Hook {
+	h := &tracingHook{}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *tracingHook) Before(ctx context.Context, e *db.QueryEvent) context.Context {
+	tracer := h.tracer
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+
+	name := e.Query.Name
+	if name == "" {
+		name = "db." + e.Operation
+	}
+
+	var spanOpts []opentracing.StartSpanOption
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		spanOpts = append(spanOpts, opentracing.ChildOf(parent.Context()))
+	}
+
+	span := tracer.StartSpan(name, spanOpts...)
+	ext.SpanKindRPCClient.Set(span)
+	ext.DBType.Set(span, "postgresql")
+
+	statement := e.Query.QueryRaw
+	if h.pretty {
+		statement = prettier.Pretty(e.Query.QueryRaw, prettier.PlaceholderDollar, e.Args...)
+	}
+	ext.DBStatement.Set(span, statement)
+	span.SetTag("db.operation", e.Operation)
+	span.SetTag("db.in_tx", e.InTx)
+
+	ctx = opentracing.ContextWithSpan(ctx, span)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (h *tracingHook) After(ctx context.Context, e *db.QueryEvent) {
+	span, ok := ctx.Value(spanKey{}).(opentracing.Span)
+	if !ok {
+		return
+	}
+
+	span.SetTag("db.rows_affected", e.RowsAffected)
+	if e.Err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(e.Err))
+	}
+
+	span.Finish()
+}
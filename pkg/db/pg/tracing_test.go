@@ -0,0 +1,65 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+func TestTracingHook(t *testing.T) {
+	tracer := mocktracer.New()
+	hook := NewTracingHook(WithTracer(tracer), WithPrettyStatement())
+
+	parent := tracer.StartSpan("handler")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	t.Run("successful query", func(t *testing.T) {
+		tracer.Reset()
+
+		e := &db.QueryEvent{
+			Operation: db.OpExec,
+			Query:     db.Query{Name: "user_repository.Update", QueryRaw: "UPDATE users SET name = $1"},
+			Args:      []interface{}{"John"},
+			InTx:      true,
+		}
+		hookCtx := hook.Before(ctx, e)
+		e.RowsAffected = 3
+		hook.After(hookCtx, e)
+
+		spans := tracer.FinishedSpans()
+		require.Len(t, spans, 1)
+
+		span := spans[0]
+		require.Equal(t, "user_repository.Update", span.OperationName)
+		require.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, span.ParentID)
+		require.Equal(t, "postgresql", span.Tag("db.type"))
+		require.Equal(t, `UPDATE users SET name = "John"`, span.Tag("db.statement"))
+		require.Equal(t, int64(3), span.Tag("db.rows_affected"))
+		require.Equal(t, true, span.Tag("db.in_tx"))
+		require.Nil(t, span.Tag("error"))
+	})
+
+	t.Run("failed query", func(t *testing.T) {
+		tracer.Reset()
+
+		e := &db.QueryEvent{
+			Operation: db.OpQuery,
+			Query:     db.Query{QueryRaw: "SELECT 1"},
+		}
+		hookCtx := hook.Before(ctx, e)
+		e.Err = errors.New("connection refused")
+		hook.After(hookCtx, e)
+
+		spans := tracer.FinishedSpans()
+		require.Len(t, spans, 1)
+		require.Equal(t, "db.query", spans[0].OperationName)
+		require.Equal(t, true, spans[0].Tag("error"))
+		require.Equal(t, false, spans[0].Tag("db.in_tx"))
+		require.Len(t, spans[0].Logs(), 1)
+	})
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+// ErrNotFound is returned by Get and Scalar when the query returns no rows
+var ErrNotFound error = sys.NewError("not found", codes.NotFound)
+
+// IsNotFound reports whether err means that no rows were found:
+// ErrNotFound, pgx.ErrNoRows or a sys error with the NotFound code, for example from the error hook
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrNotFound) || errors.Is(err, pgx.ErrNoRows) {
+		return true
+	}
+
+	sysErr := sys.GetError(err)
+	return sysErr != nil && sysErr.Code() == codes.NotFound
+}
+
+// Get scans a single row into a new T with ScanOneContext, using the transaction from ctx if present
+func Get[T any](ctx context.Context, e NamedExecer, q Query, args ...interface{}) (T, error) {
+	var dest T
+	if err := e.ScanOneContext(ctx, &dest, q, args...); err != nil {
+		var zero T
+		return zero, notFound(err, q)
+	}
+
+	return dest, nil
+}
+
+// Select scans all rows into a slice of T with ScanAllContext, it returns an empty slice when there are no rows
+func Select[T any](ctx context.Context, e NamedExecer, q Query, args ...interface{}) ([]T, error) {
+	dest := make([]T, 0)
+	if err := e.ScanAllContext(ctx, &dest, q, args...); err != nil {
+		return nil, err
+	}
+
+	return dest, nil
+}
+
+// Scalar returns the single column of a single row, for example a count or an id
+func Scalar[T any](ctx context.Context, e QueryExecer, q Query, args ...interface{}) (T, error) {
+	var dest T
+	if err := e.QueryRowContext(ctx, q, args...).Scan(&dest); err != nil {
+		var zero T
+		return zero, notFound(err, q)
+	}
+
+	return dest, nil
+}
+
+// Exists reports whether the query returns at least one row, the query is wrapped in SELECT EXISTS.
+// A trailing semicolon is removed, the query is wrapped on separate lines so a trailing comment is kept.
+func Exists(ctx context.Context, e QueryExecer, q Query, args ...interface{}) (bool, error) {
+	q.QueryRaw = "SELECT EXISTS (\n" + strings.TrimRight(q.QueryRaw, " \t\r\n;") + "\n)"
+
+	return Scalar[bool](ctx, e, q, args...)
+}
+
+// notFound replaces no rows errors with ErrNotFound wrapped with the query name
+func notFound(err error, q Query) error {
+	if IsNotFound(err) {
+		return errors.Wrapf(ErrNotFound, "query %s", q.Name)
+	}
+
+	return err
+}
@@ -0,0 +1,110 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+	"github.com/t34-dev/go-utils/pkg/sys"
+	"github.com/t34-dev/go-utils/pkg/sys/codes"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestGenericHelpers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("get", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Get").WithArgs(int64(1)).
+			WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "John"))
+
+		u, err := db.Get[user](ctx, m, db.Query{Name: "user.Get"}, int64(1))
+		require.NoError(t, err)
+		require.Equal(t, user{ID: 1, Name: "John"}, u)
+	})
+
+	t.Run("get not found", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Get").WillReturnRows(dbtest.NewRows("id", "name"))
+
+		_, err := db.Get[user](ctx, m, db.Query{Name: "user.Get"}, int64(1))
+		require.True(t, db.IsNotFound(err))
+		require.ErrorIs(t, err, db.ErrNotFound)
+		require.Contains(t, err.Error(), "user.Get")
+		require.Equal(t, codes.NotFound, sys.GetError(err).Code())
+	})
+
+	t.Run("select", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.List").
+			WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "John").AddRow(2, "Jane"))
+		m.ExpectQuery("user.List").WillReturnRows(dbtest.NewRows("id", "name"))
+
+		users, err := db.Select[user](ctx, m, db.Query{Name: "user.List"})
+		require.NoError(t, err)
+		require.Equal(t, []user{{ID: 1, Name: "John"}, {ID: 2, Name: "Jane"}}, users)
+
+		users, err = db.Select[user](ctx, m, db.Query{Name: "user.List"})
+		require.NoError(t, err)
+		require.NotNil(t, users)
+		require.Empty(t, users)
+	})
+
+	t.Run("scalar and exists", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Count").WillReturnRows(dbtest.NewRows("count").AddRow(int64(42)))
+		m.ExpectQuerySQL(`^SELECT EXISTS \(\nSELECT 1 FROM users WHERE id = \$1\n\)$`).
+			WithArgs(int64(1)).
+			WillReturnRows(dbtest.NewRows("exists").AddRow(true))
+
+		count, err := db.Scalar[int64](ctx, m, db.Query{Name: "user.Count", QueryRaw: "SELECT count(*) FROM users"})
+		require.NoError(t, err)
+		require.Equal(t, int64(42), count)
+
+		exists, err := db.Exists(ctx, m, db.Query{Name: "user.Exists", QueryRaw: "SELECT 1 FROM users WHERE id = $1"}, int64(1))
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.NoError(t, m.ExpectationsWereMet())
+	})
+
+	t.Run("exists with semicolon and trailing comment", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Exists").WillReturnRows(dbtest.NewRows("exists").AddRow(true))
+		m.ExpectQuery("user.Exists").WillReturnRows(dbtest.NewRows("exists").AddRow(false))
+
+		exists, err := db.Exists(ctx, m, db.Query{Name: "user.Exists", QueryRaw: "SELECT 1 FROM users WHERE id = $1;\n"}, int64(1))
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		exists, err = db.Exists(ctx, m, db.Query{Name: "user.Exists", QueryRaw: "SELECT 1 FROM users WHERE id = $1 -- by id"}, int64(2))
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		calls := m.Calls()
+		require.Equal(t, "SELECT EXISTS (\nSELECT 1 FROM users WHERE id = $1\n)", calls[0].Query.QueryRaw)
+		require.Equal(t, "SELECT EXISTS (\nSELECT 1 FROM users WHERE id = $1 -- by id\n)", calls[1].Query.QueryRaw)
+	})
+
+	t.Run("other errors are kept", func(t *testing.T) {
+		queryErr := errors.New("connection reset")
+		m := dbtest.New()
+		m.ExpectQuery("user.Get").WillReturnError(queryErr)
+
+		_, err := db.Get[user](ctx, m, db.Query{Name: "user.Get"})
+		require.ErrorIs(t, err, queryErr)
+		require.False(t, db.IsNotFound(err))
+	})
+
+	t.Run("translated errors are not found", func(t *testing.T) {
+		require.True(t, db.IsNotFound(sys.NewError("user not found", codes.NotFound)))
+		require.False(t, db.IsNotFound(sys.NewError("bad id", codes.InvalidArgument)))
+		require.False(t, db.IsNotFound(nil))
+	})
+}
@@ -0,0 +1,218 @@
+// Package registry loads named queries from .sql files, usually embedded with embed.FS.
+// Every query starts with a name annotation and lasts until the next one:
+//
+//	-- name: GetUser
+//	SELECT id, name FROM users WHERE id = $1;
+//
+//	-- name: ListUsers
+//	SELECT id, name FROM users ORDER BY id;
+package registry
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+// ErrUnknownQuery is returned for names that are not in the registry
+var ErrUnknownQuery = errors.New("unknown query")
+
+// nameRe matches the name annotation line
+var nameRe = regexp.MustCompile(`^--\s*name:\s*(\S+)\s*$`)
+
+// Registry holds named queries, db.Query.Name is set to the annotated name
+type Registry struct {
+	queries map[string]db.Query
+}
+
+type options struct {
+	dir string
+}
+
+// Option configures loading
+type Option func(*options)
+
+// WithDir sets the directory that is searched for .sql files recursively, "." by default
+func WithDir(dir string) Option {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+// Load parses all .sql files in fsys. Duplicate names, queries without SQL and
+// statements before the first name annotation are errors.
+func Load(fsys fs.FS, opts ...Option) (*Registry, error) {
+	o := &options{dir: "."}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	r := &Registry{queries: make(map[string]db.Query)}
+	err := fs.WalkDir(fsys, o.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".sql" {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return errors.Wrapf(err, "can't read %s", p)
+		}
+
+		return r.parse(p, string(data))
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load queries")
+	}
+
+	return r, nil
+}
+
+// MustLoad is Load that panics on error, for package level variables
+func MustLoad(fsys fs.FS, opts ...Option) *Registry {
+	r, err := Load(fsys, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+func (r *Registry) parse(file, content string) error {
+	var (
+		name string
+		body []string
+	)
+
+	flush := func() error {
+		if name == "" {
+			return nil
+		}
+
+		sql := strings.TrimSuffix(strings.TrimSpace(strings.Join(body, "\n")), ";")
+		if sql == "" {
+			return errors.Errorf("%s: query %s has no SQL", file, name)
+		}
+		if _, ok := r.queries[name]; ok {
+			return errors.Errorf("%s: duplicate query %s", file, name)
+		}
+
+		r.queries[name] = db.Query{Name: name, QueryRaw: sql}
+		return nil
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		if match := nameRe.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			name, body = match[1], nil
+			continue
+		}
+
+		if name == "" {
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return errors.Errorf("%s:%d: SQL before the first name annotation", file, i+1)
+			}
+			continue
+		}
+
+		body = append(body, line)
+	}
+
+	return flush()
+}
+
+// Query returns the query with name
+func (r *Registry) Query(name string) (db.Query, error) {
+	q, ok := r.queries[name]
+	if !ok {
+		return db.Query{}, errors.Wrap(ErrUnknownQuery, name)
+	}
+
+	return q, nil
+}
+
+// MustQuery returns the query with name and panics if there is none,
+// use it in package level variables or constructors so typos fail at startup
+func (r *Registry) MustQuery(name string) db.Query {
+	q, err := r.Query(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return q
+}
+
+// Require returns an error listing the names that are not in the registry
+func (r *Registry) Require(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if _, ok := r.queries[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Wrap(ErrUnknownQuery, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Names returns the sorted names of all queries
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.queries))
+	for name := range r.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Prepare checks that every query prepares against the database, for example in a startup check or CI.
+// It runs in a transaction that is rolled back, every query is prepared in its own savepoint
+// so all broken queries are reported at once.
+func (r *Registry) Prepare(ctx context.Context, t db.Transactor) error {
+	tx, err := t.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var failed []string
+	for _, name := range r.Names() {
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return errors.Wrap(err, "can't create savepoint")
+		}
+
+		// the unnamed statement is not cached by pgx and is replaced by the next one
+		if _, err = sp.Prepare(ctx, "", r.queries[name].QueryRaw); err != nil {
+			failed = append(failed, name+": "+err.Error())
+		}
+
+		if err = sp.Rollback(ctx); err != nil {
+			return errors.Wrap(err, "can't roll back savepoint")
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("queries failed to prepare: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/users.sql": {Data: []byte(`-- Queries for the users table
+
+-- name: GetUser
+SELECT id, name
+FROM users
+WHERE id = $1;
+
+--name:ListUsers
+-- newest first
+SELECT id, name FROM users ORDER BY id DESC;
+`)},
+		"sql/orders/orders.sql": {Data: []byte("-- name: CountOrders\nSELECT count(*) FROM orders\n")},
+		"sql/README.md":         {Data: []byte("-- name: Ignored\nnot sql")},
+	}
+
+	t.Run("queries by name", func(t *testing.T) {
+		r, err := Load(fsys, WithDir("sql"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"CountOrders", "GetUser", "ListUsers"}, r.Names())
+
+		require.Equal(t, db.Query{Name: "GetUser", QueryRaw: "SELECT id, name\nFROM users\nWHERE id = $1"}, r.MustQuery("GetUser"))
+		require.Equal(t, "-- newest first\nSELECT id, name FROM users ORDER BY id DESC", r.MustQuery("ListUsers").QueryRaw)
+		require.Equal(t, "SELECT count(*) FROM orders", r.MustQuery("CountOrders").QueryRaw)
+	})
+
+	t.Run("unknown names", func(t *testing.T) {
+		r := MustLoad(fsys)
+
+		_, err := r.Query("GetUsr")
+		require.ErrorIs(t, err, ErrUnknownQuery)
+		require.Panics(t, func() { r.MustQuery("GetUsr") })
+		require.NoError(t, r.Require("GetUser", "ListUsers"))
+
+		err = r.Require("GetUser", "GetUsr", "DeleteUser")
+		require.ErrorIs(t, err, ErrUnknownQuery)
+		require.Contains(t, err.Error(), "GetUsr, DeleteUser")
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"duplicate": "-- name: A\nSELECT 1;\n-- name: A\nSELECT 2;",
+			"empty":     "-- name: A\n\n-- name: B\nSELECT 2;",
+			"unnamed":   "SELECT 1;\n-- name: A\nSELECT 2;",
+		} {
+			_, err := Load(fstest.MapFS{"q.sql": {Data: []byte(content)}})
+			require.Error(t, err, name)
+		}
+	})
+}
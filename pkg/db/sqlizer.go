@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+)
+
+// ToQuery builds a named Query from a squirrel builder.
+// Placeholders are converted to the Dollar format, so builders don't need PlaceholderFormat(squirrel.Dollar).
+// Escaped ?? (for example the jsonb ? operator) becomes a literal ?.
+func ToQuery(name string, b squirrel.Sqlizer) (Query, []interface{}, error) {
+	sql, args, err := withQuestion(b).ToSql()
+	if err != nil {
+		return Query{}, nil, errors.Wrapf(err, "can't build query %s", name)
+	}
+
+	sql, err = squirrel.Dollar.ReplacePlaceholders(sql)
+	if err != nil {
+		return Query{}, nil, errors.Wrapf(err, "can't build query %s", name)
+	}
+
+	return Query{Name: name, QueryRaw: sql}, args, nil
+}
+
+// withQuestion makes builders emit ? placeholders, so a builder already set to Dollar doesn't
+// unescape ?? before ToQuery converts the placeholders
+func withQuestion(b squirrel.Sqlizer) squirrel.Sqlizer {
+	switch v := b.(type) {
+	case squirrel.SelectBuilder:
+		return v.PlaceholderFormat(squirrel.Question)
+	case squirrel.InsertBuilder:
+		return v.PlaceholderFormat(squirrel.Question)
+	case squirrel.UpdateBuilder:
+		return v.PlaceholderFormat(squirrel.Question)
+	case squirrel.DeleteBuilder:
+		return v.PlaceholderFormat(squirrel.Question)
+	default:
+		return b
+	}
+}
+
+// ExecBuilder builds the query and executes it with ExecContext
+func ExecBuilder(ctx context.Context, e QueryExecer, name string, b squirrel.Sqlizer) (pgconn.CommandTag, error) {
+	q, args, err := ToQuery(name, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ExecContext(ctx, q, args...)
+}
+
+// ScanOneBuilder builds the query and scans a single row into dest with ScanOneContext
+func ScanOneBuilder(ctx context.Context, e NamedExecer, dest interface{}, name string, b squirrel.Sqlizer) error {
+	q, args, err := ToQuery(name, b)
+	if err != nil {
+		return err
+	}
+
+	return e.ScanOneContext(ctx, dest, q, args...)
+}
+
+// ScanAllBuilder builds the query and scans all rows into dest with ScanAllContext
+func ScanAllBuilder(ctx context.Context, e NamedExecer, dest interface{}, name string, b squirrel.Sqlizer) error {
+	q, args, err := ToQuery(name, b)
+	if err != nil {
+		return err
+	}
+
+	return e.ScanAllContext(ctx, dest, q, args...)
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToQuery(t *testing.T) {
+	t.Run("question placeholders are converted", func(t *testing.T) {
+		builder := sq.Select("id", "name").
+			From("users").
+			Where(sq.Eq{"id": 1}).
+			Where(sq.Gt{"age": 18})
+
+		q, args, err := ToQuery("user.List", builder)
+		require.NoError(t, err)
+		require.Equal(t, "user.List", q.Name)
+		require.Equal(t, "SELECT id, name FROM users WHERE id = $1 AND age > $2", q.QueryRaw)
+		require.Equal(t, []interface{}{1, 18}, args)
+	})
+
+	t.Run("dollar placeholders are kept", func(t *testing.T) {
+		builder := sq.Update("users").
+			PlaceholderFormat(sq.Dollar).
+			Set("name", "John").
+			Where(sq.Eq{"id": 1})
+
+		q, _, err := ToQuery("user.Update", builder)
+		require.NoError(t, err)
+		require.Equal(t, "UPDATE users SET name = $1 WHERE id = $2", q.QueryRaw)
+	})
+
+	t.Run("escaped question marks of a dollar builder", func(t *testing.T) {
+		builder := sq.Select("id").
+			PlaceholderFormat(sq.Dollar).
+			From("documents").
+			Where("data ?? ?", "key").
+			Where("tags ??| ?", []string{"a", "b"}).
+			Where("tags ??& ARRAY['c']")
+
+		q, _, err := ToQuery("document.List", builder)
+		require.NoError(t, err)
+		require.Equal(t, "SELECT id FROM documents WHERE data ? $1 AND tags ?| $2 AND tags ?& ARRAY['c']", q.QueryRaw)
+
+		q, _, err = ToQuery("document.List", builder.PlaceholderFormat(sq.Question))
+		require.NoError(t, err)
+		require.Equal(t, "SELECT id FROM documents WHERE data ? $1 AND tags ?| $2 AND tags ?& ARRAY['c']", q.QueryRaw)
+	})
+
+	t.Run("build error is wrapped with the query name", func(t *testing.T) {
+		_, _, err := ToQuery("user.Insert", sq.Insert(""))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user.Insert")
+	})
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// ErrStopStream can be returned from a Stream callback to stop reading without an error
+var ErrStopStream = errors.New("stop stream")
+
+// Iter scans rows one by one into T, only the current row is kept in memory.
+// Close must be called when the iteration is stopped early.
+type Iter[T any] struct {
+	ctx     context.Context
+	rows    pgx.Rows
+	scanner *pgxscan.RowScanner
+	err     error
+}
+
+// NewIter wraps rows into a typed iterator
+func NewIter[T any](ctx context.Context, rows pgx.Rows) *Iter[T] {
+	return &Iter[T]{
+		ctx:     ctx,
+		rows:    rows,
+		scanner: pgxscan.NewRowScanner(rows),
+	}
+}
+
+// Iterate runs the query with QueryContext and returns an iterator over its rows
+func Iterate[T any](ctx context.Context, e QueryExecer, q Query, args ...interface{}) (*Iter[T], error) {
+	rows, err := e.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIter[T](ctx, rows), nil
+}
+
+// IterateCursor runs the query with CursorContext, fetching fetchSize rows at a time
+func IterateCursor[T any](ctx context.Context, c Cursorer, q Query, fetchSize int, args ...interface{}) (*Iter[T], error) {
+	rows, err := c.CursorContext(ctx, q, fetchSize, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIter[T](ctx, rows), nil
+}
+
+// Next advances to the next row, it returns false when the rows are exhausted, on error or when ctx is done
+func (it *Iter[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.rows.Close()
+		return false
+	}
+
+	return it.rows.Next()
+}
+
+// Scan scans the current row into a new T
+func (it *Iter[T]) Scan() (T, error) {
+	var dest T
+	if err := it.scanner.Scan(&dest); err != nil {
+		it.err = err
+		var zero T
+		return zero, err
+	}
+
+	return dest, nil
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iter[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.rows.Err()
+}
+
+// Close releases the rows, it is safe to call Close several times
+func (it *Iter[T]) Close() {
+	it.rows.Close()
+}
+
+// Stream calls fn for every row of the query scanned into T.
+// It stops at the first error returned by fn, ErrStopStream stops it without an error.
+func Stream[T any](ctx context.Context, e QueryExecer, q Query, fn func(ctx context.Context, v T) error, args ...interface{}) error {
+	it, err := Iterate[T](ctx, e, q, args...)
+	if err != nil {
+		return err
+	}
+
+	return stream(ctx, it, fn)
+}
+
+// StreamCursor is Stream over a server-side cursor, fetching fetchSize rows at a time
+func StreamCursor[T any](ctx context.Context, c Cursorer, q Query, fetchSize int, fn func(ctx context.Context, v T) error, args ...interface{}) error {
+	it, err := IterateCursor[T](ctx, c, q, fetchSize, args...)
+	if err != nil {
+		return err
+	}
+
+	return stream(ctx, it, fn)
+}
+
+func stream[T any](ctx context.Context, it *Iter[T], fn func(ctx context.Context, v T) error) error {
+	defer it.Close()
+
+	for it.Next() {
+		v, err := it.Scan()
+		if err != nil {
+			return errors.Wrap(err, "can't scan row")
+		}
+
+		if err = fn(ctx, v); err != nil {
+			if errors.Is(err, ErrStopStream) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return it.Err()
+}
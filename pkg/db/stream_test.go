@@ -0,0 +1,81 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/t34-dev/go-utils/pkg/db"
+	"github.com/t34-dev/go-utils/pkg/db/dbtest"
+)
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	q := db.Query{Name: "user.Export", QueryRaw: "SELECT id, name FROM users"}
+	rows := func() *dbtest.Rows {
+		return dbtest.NewRows("id", "name").AddRow(1, "John").AddRow(2, "Jane").AddRow(3, "Jack")
+	}
+
+	t.Run("iterator", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Export").WillReturnRows(rows())
+
+		it, err := db.IterateCursor[user](ctx, m, q, 2)
+		require.NoError(t, err)
+		defer it.Close()
+
+		var names []string
+		for it.Next() {
+			u, err := it.Scan()
+			require.NoError(t, err)
+			names = append(names, u.Name)
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []string{"John", "Jane", "Jack"}, names)
+	})
+
+	t.Run("early stop", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Export").WillReturnRows(rows())
+
+		var ids []int64
+		err := db.Stream(ctx, m, q, func(ctx context.Context, u user) error {
+			ids = append(ids, u.ID)
+			if len(ids) == 2 {
+				return db.ErrStopStream
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 2}, ids)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Export").WillReturnRows(rows())
+
+		writeErr := errors.New("disk full")
+		err := db.StreamCursor(ctx, m, q, 100, func(ctx context.Context, u user) error {
+			return writeErr
+		})
+		require.ErrorIs(t, err, writeErr)
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		m := dbtest.New()
+		m.ExpectQuery("user.Export").WillReturnRows(rows())
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var count int
+		err := db.Stream(ctx, m, q, func(ctx context.Context, u user) error {
+			count++
+			cancel()
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, count)
+	})
+}
@@ -0,0 +1,16 @@
+package db
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context for queries of the tenant, used by clients with tenant isolation
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set with WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
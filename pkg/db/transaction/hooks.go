@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type hooksKey struct{}
+
+// ErrNoTransaction is returned when a hook is registered outside of a managed transaction
+var ErrNoTransaction = errors.New("no transaction in context")
+
+// Hook - function that is executed after the outermost transaction finishes
+type Hook func(ctx context.Context) error
+
+// HookErrorFunc receives errors returned by OnCommit and OnRollback hooks
+type HookErrorFunc func(ctx context.Context, err error)
+
+type hooks struct {
+	mu         sync.Mutex
+	onCommit   []Hook
+	onRollback []Hook
+}
+
+// OnCommit registers a hook that is executed after the outermost transaction in ctx commits
+func OnCommit(ctx context.Context, hook Hook) error {
+	h, ok := ctx.Value(hooksKey{}).(*hooks)
+	if !ok {
+		return ErrNoTransaction
+	}
+
+	h.mu.Lock()
+	h.onCommit = append(h.onCommit, hook)
+	h.mu.Unlock()
+
+	return nil
+}
+
+// OnRollback registers a hook that is executed after the outermost transaction in ctx is rolled back
+func OnRollback(ctx context.Context, hook Hook) error {
+	h, ok := ctx.Value(hooksKey{}).(*hooks)
+	if !ok {
+		return ErrNoTransaction
+	}
+
+	h.mu.Lock()
+	h.onRollback = append(h.onRollback, hook)
+	h.mu.Unlock()
+
+	return nil
+}
+
+func withHooks(ctx context.Context, h *hooks) context.Context {
+	return context.WithValue(ctx, hooksKey{}, h)
+}
+
+// run executes the hooks registered for the transaction outcome.
+// Hook errors are passed to onError and never change the transaction result.
+func (h *hooks) run(ctx context.Context, committed bool, onError HookErrorFunc) {
+	h.mu.Lock()
+	list := h.onRollback
+	if committed {
+		list = h.onCommit
+	}
+	h.onCommit, h.onRollback = nil, nil
+	h.mu.Unlock()
+
+	for _, hook := range list {
+		if err := runHook(ctx, hook); err != nil {
+			onError(ctx, err)
+		}
+	}
+}
+
+func runHook(ctx context.Context, hook Hook) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic recovered in transaction hook: %v", r)
+		}
+	}()
+
+	return hook(ctx)
+}
@@ -2,6 +2,8 @@ package transaction
 
 import (
 	"context"
+	"log"
+
 	"github.com/t34-dev/go-utils/pkg/db"
 	"github.com/t34-dev/go-utils/pkg/db/pg"
 
@@ -10,14 +12,36 @@ import (
 )
 
 type manager struct {
-	db db.Transactor
+	db          db.Transactor
+	hookErrFunc HookErrorFunc
+}
+
+// Option configures the transaction manager
+type Option func(*manager)
+
+// WithHookErrorFunc sets the function that receives errors returned by OnCommit and OnRollback hooks, nil keeps the default logging
+func WithHookErrorFunc(f HookErrorFunc) Option {
+	return func(m *manager) {
+		if f != nil {
+			m.hookErrFunc = f
+		}
+	}
 }
 
 // NewTransactionManager creates a new transaction manager that satisfies the db.TxManager interface
-func NewTransactionManager(db db.Transactor) db.TxManager {
-	return &manager{
+func NewTransactionManager(db db.Transactor, opts ...Option) db.TxManager {
+	m := &manager{
 		db: db,
+		hookErrFunc: func(ctx context.Context, err error) {
+			log.Println("transaction hook failed:", err)
+		},
+	}
+
+	for _, opt := range opts {
+		opt(m)
 	}
+
+	return m
 }
 
 // transaction is the main function that executes a user-provided handler in a transaction
@@ -34,8 +58,11 @@ func (m *manager) transaction(ctx context.Context, opts pgx.TxOptions, fn db.Han
 		return errors.Wrap(err, "can't begin transaction")
 	}
 
-	// Put the transaction in the context.
-	ctx = pg.MakeContextTx(ctx, tx)
+	// Put the transaction and its hooks in the context.
+	// Hooks run with the original context, so they never see the finished transaction.
+	hookCtx := ctx
+	h := &hooks{}
+	ctx = withHooks(pg.MakeContextTx(ctx, tx), h)
 
 	// Set up a defer function for rollback or commit the transaction.
 	defer func() {
@@ -50,6 +77,7 @@ func (m *manager) transaction(ctx context.Context, opts pgx.TxOptions, fn db.Han
 				err = errors.Wrapf(err, "errRollback: %v", errRollback)
 			}
 
+			h.run(hookCtx, false, m.hookErrFunc)
 			return
 		}
 
@@ -58,7 +86,11 @@ func (m *manager) transaction(ctx context.Context, opts pgx.TxOptions, fn db.Han
 			err = tx.Commit(ctx)
 			if err != nil {
 				err = errors.Wrap(err, "tx commit failed")
+				h.run(hookCtx, false, m.hookErrFunc)
+				return
 			}
+
+			h.run(hookCtx, true, m.hookErrFunc)
 		}
 	}()
 
@@ -0,0 +1,142 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTx struct {
+	pgx.Tx
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (t *fakeTx) Commit(ctx context.Context) error {
+	t.committed = true
+	return t.commitErr
+}
+
+func (t *fakeTx) Rollback(ctx context.Context) error {
+	t.rolledBack = true
+	return nil
+}
+
+type fakeTransactor struct {
+	tx *fakeTx
+}
+
+func (f *fakeTransactor) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	return f.tx, nil
+}
+
+func TestTransactionHooks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commit hooks run after commit", func(t *testing.T) {
+		tx := &fakeTx{}
+		m := NewTransactionManager(&fakeTransactor{tx: tx})
+
+		var calls []string
+		err := m.ReadCommitted(ctx, func(ctx context.Context) error {
+			require.NoError(t, OnCommit(ctx, func(ctx context.Context) error {
+				require.True(t, tx.committed)
+				calls = append(calls, "commit")
+				return nil
+			}))
+			require.NoError(t, OnRollback(ctx, func(ctx context.Context) error {
+				calls = append(calls, "rollback")
+				return nil
+			}))
+
+			// nested transaction registers hooks on the outermost one
+			return m.ReadCommitted(ctx, func(ctx context.Context) error {
+				return OnCommit(ctx, func(ctx context.Context) error {
+					calls = append(calls, "nested commit")
+					return nil
+				})
+			})
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"commit", "nested commit"}, calls)
+	})
+
+	t.Run("rollback hooks run after failed handler", func(t *testing.T) {
+		tx := &fakeTx{}
+		m := NewTransactionManager(&fakeTransactor{tx: tx})
+
+		var calls []string
+		err := m.ReadCommitted(ctx, func(ctx context.Context) error {
+			require.NoError(t, OnCommit(ctx, func(ctx context.Context) error {
+				calls = append(calls, "commit")
+				return nil
+			}))
+			require.NoError(t, OnRollback(ctx, func(ctx context.Context) error {
+				calls = append(calls, "rollback")
+				return nil
+			}))
+			return errors.New("handler failed")
+		})
+		require.Error(t, err)
+		require.True(t, tx.rolledBack)
+		require.Equal(t, []string{"rollback"}, calls)
+	})
+
+	t.Run("rollback hooks run after failed commit", func(t *testing.T) {
+		tx := &fakeTx{commitErr: errors.New("commit failed")}
+		m := NewTransactionManager(&fakeTransactor{tx: tx})
+
+		var calls []string
+		err := m.ReadCommitted(ctx, func(ctx context.Context) error {
+			require.NoError(t, OnCommit(ctx, func(ctx context.Context) error {
+				calls = append(calls, "commit")
+				return nil
+			}))
+			return OnRollback(ctx, func(ctx context.Context) error {
+				calls = append(calls, "rollback")
+				return nil
+			})
+		})
+		require.Error(t, err)
+		require.Equal(t, []string{"rollback"}, calls)
+	})
+
+	t.Run("hook errors do not change the result", func(t *testing.T) {
+		var hookErrs []error
+		m := NewTransactionManager(&fakeTransactor{tx: &fakeTx{}}, WithHookErrorFunc(func(ctx context.Context, err error) {
+			hookErrs = append(hookErrs, err)
+		}))
+
+		err := m.ReadCommitted(ctx, func(ctx context.Context) error {
+			require.NoError(t, OnCommit(ctx, func(ctx context.Context) error {
+				return errors.New("publish failed")
+			}))
+			return OnCommit(ctx, func(ctx context.Context) error {
+				panic("boom")
+			})
+		})
+		require.NoError(t, err)
+		require.Len(t, hookErrs, 2)
+	})
+
+	t.Run("nil hook error func keeps the default", func(t *testing.T) {
+		m := NewTransactionManager(&fakeTransactor{tx: &fakeTx{}}, WithHookErrorFunc(nil))
+		require.NotNil(t, m.(*manager).hookErrFunc)
+
+		err := m.ReadCommitted(ctx, func(ctx context.Context) error {
+			return OnCommit(ctx, func(ctx context.Context) error {
+				return errors.New("publish failed")
+			})
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("registering outside a transaction fails", func(t *testing.T) {
+		err := OnCommit(ctx, func(ctx context.Context) error { return nil })
+		require.ErrorIs(t, err, ErrNoTransaction)
+	})
+}